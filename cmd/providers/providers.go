@@ -1,15 +1,46 @@
 package providers
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
 	"github.com/EdwinPirajan/bloqueo.git/internal/core/services"
 	"github.com/getlantern/systray"
 )
 
+// shutdownTimeout limita cuánto se espera a que el monitor libere todo al
+// salir. Si se agota, lo pendiente se recupera del registro en el próximo inicio.
+const shutdownTimeout = 10 * time.Second
+
+// lifecycle agrupa lo necesario para detener el agente de forma ordenada.
+type lifecycle struct {
+	monitorCtx  context.Context
+	stopMonitor context.CancelFunc
+	monitorDone chan struct{}
+	wsCtx       context.Context
+	stopWS      context.CancelFunc
+}
+
+func newLifecycle() *lifecycle {
+	l := &lifecycle{monitorDone: make(chan struct{})}
+	l.monitorCtx, l.stopMonitor = context.WithCancel(context.Background())
+	l.wsCtx, l.stopWS = context.WithCancel(context.Background())
+	return l
+}
+
 func Run() {
+	// En servidores de terminales (Citrix/RDS) un solo agente con privilegios
+	// supervisa todas las sesiones interactivas en lugar de una copia por sesión.
+	multiSession := flag.Bool("multisession", false, "supervisar todas las sesiones interactivas del equipo")
+	flag.Parse()
+
 	cliente := "latam"
 	baseWebSocketURL := "ws://10.96.16.67:8080/api/v1/ws"
 
@@ -23,15 +54,38 @@ func Run() {
 	if err != nil {
 		log.Printf("Error obteniendo la configuración: %v", err)
 	}
+	services.SetCurrentConfig(config)
 
 	wsURL := fmt.Sprintf("%s/%s/%s", baseWebSocketURL, user.Name, cliente)
 
-	systemManager := services.NewWindowsSystemManager()
+	systemManager := services.NewSystemManager()
+
+	// Liberar lo que haya quedado restringido si la ejecución anterior terminó
+	// sin limpiar (cierre inesperado, runsb.bat, update.bat).
+	if err := services.RecoverFromLedger(systemManager, *multiSession); err != nil {
+		log.Printf("Error recuperando el estado de la ejecución anterior: %v", err)
+	}
+	// Las órdenes de los supervisores siguen vigentes tras un reinicio.
+	if err := services.LoadOverrides(); err != nil {
+		log.Printf("Error cargando las órdenes vigentes: %v", err)
+	}
+
+	lc := newLifecycle()
+
+	// Ctrl+C, cierre de la consola, cierre de sesión o apagado (en Windows
+	// llegan como SIGTERM) cierran el systray, lo que ejecuta onExit.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Señal %v recibida, deteniendo el agente...", sig)
+		systray.Quit()
+	}()
 
-	systray.Run(func() { onReady(systemManager, config, wsURL, &user) }, onExit)
+	systray.Run(func() { onReady(lc, systemManager, config, wsURL, &user, *multiSession) }, func() { onExit(lc, &user) })
 }
 
-func onReady(systemManager services.SystemManager, config services.ConfigResponse, wsURL string, user *domain.User) {
+func onReady(lc *lifecycle, systemManager services.SystemManager, config services.ConfigResponse, wsURL string, user *domain.User, multiSession bool) {
 	iconData, err := services.GetIcon("resources/icono.ico")
 	if err != nil {
 		log.Printf("Error loading icon: %v", err)
@@ -41,20 +95,73 @@ func onReady(systemManager services.SystemManager, config services.ConfigRespons
 	systray.SetTitle("ScrapeBlocker")
 	systray.SetTooltip("ScrapeBlocker")
 
-	mStatus := systray.AddMenuItem("ScrapeBlocker - LATAM Airlines v1.0.2 - Almacontact", "Almacontact")
+	mStatus := systray.AddMenuItem(fmt.Sprintf("ScrapeBlocker - LATAM Airlines v%s - Almacontact", services.AgentVersion), "Almacontact")
+	mConnection := systray.AddMenuItem(connectionLabel(services.GetConnectionState()), "Estado de la conexión con el servidor")
+	mConnection.Disable()
+	services.OnConnectionStateChange(func(state services.ConnectionState) {
+		mConnection.SetTitle(connectionLabel(state))
+	})
 
 	go func() {
-		if err := services.ConnectAndKeepOpen(wsURL, user); err != nil {
+		if err := services.ConnectAndKeepOpen(lc.wsCtx, wsURL, user); err != nil {
 			log.Printf("Error en WebSocket: %v", err)
 		}
 	}()
 
-	log.Printf("Iniciando MonitorProcesses con config: %+v y usuario: %+v", config, user)
-	go services.MonitorProcesses(systemManager, config.ProcessesToMonitor, config.UrlsToBlock, user)
+	go services.ReportAgentStatus(lc.wsCtx, user, services.DefaultStatusReportInterval)
+
+	go func() {
+		for event := range services.WatchHostsFile(lc.monitorCtx, 5*time.Second) {
+			if err := services.SendWebSocketMessage("hosts_tamper", event); err != nil {
+				log.Printf("Error reportando la manipulación del archivo hosts: %v", err)
+			}
+		}
+	}()
+
+	if multiSession {
+		log.Printf("Iniciando MonitorSessions con config: %+v", config)
+		go func() {
+			defer close(lc.monitorDone)
+			services.MonitorSessions(lc.monitorCtx, systemManager, config.ProcessesToMonitor)
+		}()
+	} else {
+		log.Printf("Iniciando MonitorProcesses con config: %+v y usuario: %+v", config, user)
+		go func() {
+			defer close(lc.monitorDone)
+			services.MonitorProcesses(lc.monitorCtx, systemManager, config.ProcessesToMonitor, config.UrlsToBlock, user)
+		}()
+	}
 
 	<-mStatus.ClickedCh
 }
 
-func onExit() {
+// onExit detiene el monitor, espera a que libere procesos, hosts y pestañas
+// (con un límite de tiempo), informa al servidor y cierra el WebSocket.
+func onExit(lc *lifecycle, user *domain.User) {
 	log.Println("Saliendo de la aplicación systray...")
+	lc.stopMonitor()
+
+	select {
+	case <-lc.monitorDone:
+		log.Println("Todos los bloqueos fueron liberados.")
+	case <-time.After(shutdownTimeout):
+		log.Printf("El monitor no terminó en %v; lo pendiente se liberará en el próximo inicio.", shutdownTimeout)
+	}
+
+	if err := services.ReportAgentStopped(user, "shutdown"); err != nil {
+		log.Printf("Error informando la detención del agente: %v", err)
+	}
+	lc.stopWS()
+}
+
+// connectionLabel es el texto del menú para el estado del canal de control.
+func connectionLabel(state services.ConnectionState) string {
+	switch state {
+	case services.ConnectionConnected:
+		return "Servidor: conectado"
+	case services.ConnectionReconnecting:
+		return "Servidor: reconectando..."
+	default:
+		return "Servidor: sin conexión"
+	}
 }
@@ -45,6 +45,6 @@ require (
 require (
 	github.com/fatih/color v1.17.0
 	github.com/getlantern/systray v1.2.2
-	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/net v0.25.0
 	golang.org/x/sys v0.25.0
 )
@@ -0,0 +1,23 @@
+package domain
+
+// Tipos de regla de detección de interacciones.
+const (
+	DetectionCSS       = "css"
+	DetectionXPath     = "xpath"
+	DetectionText      = "text"
+	DetectionAttribute = "attribute"
+)
+
+// DetectionRule describe cómo reconocer en la página de Genesys que el agente
+// tiene una interacción en curso. Value contiene el selector CSS, la expresión
+// XPath, la expresión regular de texto o el valor esperado del atributo
+// indicado en Attribute, según el tipo. Flags son las opciones de la
+// expresión regular de texto ("i", "m" o "s"), que se evalúa con RegExp de
+// JavaScript en la página.
+type DetectionRule struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	Attribute string `json:"attribute,omitempty"`
+	Flags     string `json:"flags,omitempty"`
+}
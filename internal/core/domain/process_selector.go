@@ -0,0 +1,38 @@
+package domain
+
+// Acciones con las que se restringe un proceso monitoreado.
+const (
+	EnforcementSuspend   = "suspend"
+	EnforcementMinimize  = "minimize"
+	EnforcementHide      = "hide"
+	EnforcementTerminate = "terminate"
+	EnforcementOverlay   = "overlay"
+)
+
+// ProcessSelector describe qué procesos se monitorean. Todos los campos
+// indicados deben coincidir. Las comparaciones no distinguen mayúsculas.
+//
+//   - Name: nombre del ejecutable; admite comodines tipo glob (*, ?, [..]).
+//   - Pattern: expresión regular sobre el nombre del ejecutable.
+//   - PathPrefix: prefijo de la ruta completa de la imagen.
+//   - CommandLine: subcadena de la línea de comandos.
+//   - Parent: nombre del ejecutable del proceso padre.
+//   - Publisher: subcadena del editor (CompanyName de la información de
+//     versión en Windows; no disponible en Linux).
+//
+// Action elige cómo se restringe el proceso (por defecto "suspend"): suspender,
+// minimizar u ocultar sus ventanas, terminarlo (y, con Relaunch, volver a
+// iniciarlo al liberar) o cubrirlo con una ventana de bloqueo. SuspendTree
+// indica que la acción se aplica también a todos sus descendientes. Si varios
+// selectores coinciden con un proceso, manda el primero.
+type ProcessSelector struct {
+	Name        string `json:"name,omitempty"`
+	Pattern     string `json:"pattern,omitempty"`
+	PathPrefix  string `json:"path_prefix,omitempty"`
+	CommandLine string `json:"cmdline,omitempty"`
+	Parent      string `json:"parent,omitempty"`
+	Publisher   string `json:"publisher,omitempty"`
+	SuspendTree bool   `json:"suspend_tree,omitempty"`
+	Action      string `json:"action,omitempty"`
+	Relaunch    bool   `json:"relaunch,omitempty"`
+}
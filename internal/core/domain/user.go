@@ -7,5 +7,6 @@ type User struct {
 	Name           string    `json:"name"`
 	Client         string    `json:"client,omitempty"`
 	Active         bool      `json:"active"`
+	InCall         *bool     `json:"in_call,omitempty"`
 	LastConnection time.Time `json:"last_connection"`
 }
@@ -0,0 +1,252 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Tipos de condición soportados por el motor.
+const (
+	ConditionAll            = "all"
+	ConditionAny            = "any"
+	ConditionNot            = "not"
+	ConditionCallInProgress = "call_in_progress"
+	ConditionUserActive     = "user_active"
+	ConditionUserInactive   = "user_inactive"
+	ConditionTimeWindow     = "time_window"
+	ConditionProcessRunning = "process_running"
+	ConditionServerOnline   = "server_online"
+	ConditionServerOffline  = "server_offline"
+	ConditionForcedBlock    = "forced_block"
+	ConditionForcedRelease  = "forced_release"
+)
+
+// Órdenes remotas vigentes que un supervisor puede imponer sobre la política.
+const (
+	OverrideForceBlock   = "force_block"
+	OverrideForceRelease = "force_release"
+)
+
+// Facts son los hechos recolectados por el monitor en cada ciclo y sobre los
+// cuales se evalúan las condiciones.
+type Facts struct {
+	CallInProgress   bool
+	UserActive       bool
+	Now              time.Time
+	RunningProcesses []string
+	ServerOnline     bool
+	// Override es la orden remota vigente (OverrideForceBlock u
+	// OverrideForceRelease) o vacío si no hay ninguna.
+	Override string
+}
+
+// processRunning indica si el proceso indicado está en ejecución (sin
+// distinguir mayúsculas de minúsculas).
+func (f Facts) processRunning(name string) bool {
+	for _, running := range f.RunningProcesses {
+		if strings.EqualFold(running, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Condition es una condición declarativa. Las condiciones compuestas
+// ("all", "any", "not") se expresan a través de Conditions.
+type Condition struct {
+	Type       string      `json:"type"`
+	Conditions []Condition `json:"conditions,omitempty"`
+	Process    string      `json:"process,omitempty"`
+	Start      string      `json:"start,omitempty"`
+	End        string      `json:"end,omitempty"`
+	Days       []string    `json:"days,omitempty"`
+}
+
+// All construye una condición que se cumple si se cumplen todas las indicadas.
+func All(conditions ...Condition) Condition {
+	return Condition{Type: ConditionAll, Conditions: conditions}
+}
+
+// Any construye una condición que se cumple si se cumple alguna de las indicadas.
+func Any(conditions ...Condition) Condition {
+	return Condition{Type: ConditionAny, Conditions: conditions}
+}
+
+// Not construye una condición que niega la indicada.
+func Not(condition Condition) Condition {
+	return Condition{Type: ConditionNot, Conditions: []Condition{condition}}
+}
+
+// Evaluate evalúa la condición contra los hechos recolectados.
+func (c Condition) Evaluate(f Facts) (bool, error) {
+	switch c.Type {
+	case ConditionAll, "and":
+		for _, child := range c.Conditions {
+			ok, err := child.Evaluate(f)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case ConditionAny, "or":
+		for _, child := range c.Conditions {
+			ok, err := child.Evaluate(f)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ConditionNot:
+		if len(c.Conditions) != 1 {
+			return false, fmt.Errorf("la condición 'not' requiere exactamente una condición, tiene %d", len(c.Conditions))
+		}
+		ok, err := c.Conditions[0].Evaluate(f)
+		return !ok, err
+	case ConditionCallInProgress:
+		return f.CallInProgress, nil
+	case ConditionUserActive:
+		return f.UserActive, nil
+	case ConditionUserInactive:
+		return !f.UserActive, nil
+	case ConditionServerOnline:
+		return f.ServerOnline, nil
+	case ConditionServerOffline:
+		return !f.ServerOnline, nil
+	case ConditionForcedBlock:
+		return f.Override == OverrideForceBlock, nil
+	case ConditionForcedRelease:
+		return f.Override == OverrideForceRelease, nil
+	case ConditionTimeWindow:
+		return c.inTimeWindow(f.Now)
+	case ConditionProcessRunning:
+		if c.Process == "" {
+			return false, fmt.Errorf("la condición 'process_running' requiere el campo process")
+		}
+		return f.processRunning(c.Process), nil
+	default:
+		return false, fmt.Errorf("tipo de condición desconocido: %q", c.Type)
+	}
+}
+
+// Validate verifica la estructura de la condición y de todas sus hijas sin
+// evaluarla: tipos conocidos, aridad de las compuestas y campos requeridos.
+func (c Condition) Validate() error {
+	switch c.Type {
+	case ConditionAll, "and", ConditionAny, "or":
+		if len(c.Conditions) == 0 {
+			return fmt.Errorf("la condición %q requiere al menos una condición", c.Type)
+		}
+	case ConditionNot:
+		if len(c.Conditions) != 1 {
+			return fmt.Errorf("la condición 'not' requiere exactamente una condición, tiene %d", len(c.Conditions))
+		}
+	case ConditionCallInProgress, ConditionUserActive, ConditionUserInactive,
+		ConditionServerOnline, ConditionServerOffline, ConditionForcedBlock, ConditionForcedRelease:
+	case ConditionTimeWindow:
+		if _, err := parseClock(c.Start); err != nil {
+			return err
+		}
+		if _, err := parseClock(c.End); err != nil {
+			return err
+		}
+		for _, day := range c.Days {
+			if _, ok := parseDay(day); !ok {
+				return fmt.Errorf("día inválido en la ventana horaria: %q", day)
+			}
+		}
+	case ConditionProcessRunning:
+		if c.Process == "" {
+			return fmt.Errorf("la condición 'process_running' requiere el campo process")
+		}
+	default:
+		return fmt.Errorf("tipo de condición desconocido: %q", c.Type)
+	}
+	if len(c.Conditions) > 0 && !c.composite() {
+		return fmt.Errorf("la condición %q no admite condiciones anidadas", c.Type)
+	}
+	for _, child := range c.Conditions {
+		if err := child.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Condition) composite() bool {
+	switch c.Type {
+	case ConditionAll, "and", ConditionAny, "or", ConditionNot:
+		return true
+	}
+	return false
+}
+
+// parseDay interpreta un día de la semana en inglés, completo ("monday") o
+// abreviado a tres letras ("mon"), sin distinguir mayúsculas de minúsculas.
+func parseDay(day string) (time.Weekday, bool) {
+	day = strings.ToLower(day)
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		name := strings.ToLower(weekday.String())
+		if day == name || day == name[:3] {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
+
+// uses indica si la condición o alguna de sus hijas es del tipo indicado.
+func (c Condition) uses(conditionType string) bool {
+	if c.Type == conditionType {
+		return true
+	}
+	for _, child := range c.Conditions {
+		if child.uses(conditionType) {
+			return true
+		}
+	}
+	return false
+}
+
+// inTimeWindow evalúa una ventana horaria "HH:MM"-"HH:MM" y, opcionalmente,
+// los días de la semana en que aplica. Las ventanas que cruzan la medianoche
+// (p. ej. 22:00-06:00) están soportadas.
+func (c Condition) inTimeWindow(now time.Time) (bool, error) {
+	if len(c.Days) > 0 {
+		matched := false
+		for _, day := range c.Days {
+			if weekday, ok := parseDay(day); ok && weekday == now.Weekday() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	start, err := parseClock(c.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(c.End)
+	if err != nil {
+		return false, err
+	}
+	current := now.Hour()*60 + now.Minute()
+
+	if start <= end {
+		return current >= start && current < end, nil
+	}
+	return current >= start || current < end, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("hora inválida en la ventana horaria %q: %v", value, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
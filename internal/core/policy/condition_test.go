@@ -0,0 +1,122 @@
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+// monday es un lunes cualquiera a medianoche.
+var monday = time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC)
+
+func at(day time.Time, clock string) time.Time {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		panic(err)
+	}
+	return day.Add(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute)
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	facts := Facts{
+		UserActive:       true,
+		CallInProgress:   false,
+		Now:              at(monday, "10:00"),
+		RunningProcesses: []string{"Chrome.exe", "cobis.exe"},
+		ServerOnline:     true,
+	}
+	tests := []struct {
+		name      string
+		condition Condition
+		want      bool
+	}{
+		{"usuario activo", Condition{Type: ConditionUserActive}, true},
+		{"usuario inactivo", Condition{Type: ConditionUserInactive}, false},
+		{"en llamada", Condition{Type: ConditionCallInProgress}, false},
+		{"servidor en línea", Condition{Type: ConditionServerOnline}, true},
+		{"servidor fuera de línea", Condition{Type: ConditionServerOffline}, false},
+		{"proceso sin distinguir mayúsculas", Condition{Type: ConditionProcessRunning, Process: "chrome.exe"}, true},
+		{"proceso ausente", Condition{Type: ConditionProcessRunning, Process: "excel.exe"}, false},
+		{"all", All(Condition{Type: ConditionUserActive}, Not(Condition{Type: ConditionCallInProgress})), true},
+		{"all con una falsa", All(Condition{Type: ConditionUserActive}, Condition{Type: ConditionCallInProgress}), false},
+		{"any", Any(Condition{Type: ConditionCallInProgress}, Condition{Type: ConditionServerOnline}), true},
+		{"any sin ninguna", Any(Condition{Type: ConditionCallInProgress}, Condition{Type: ConditionUserInactive}), false},
+		{"alias and", Condition{Type: "and", Conditions: []Condition{{Type: ConditionUserActive}}}, true},
+		{"alias or", Condition{Type: "or", Conditions: []Condition{{Type: ConditionUserInactive}}}, false},
+		{"bloqueo forzado sin orden", Condition{Type: ConditionForcedBlock}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.condition.Evaluate(facts)
+			if err != nil {
+				t.Fatalf("error inesperado: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionTimeWindow(t *testing.T) {
+	tuesday := monday.AddDate(0, 0, 1)
+	tests := []struct {
+		name      string
+		condition Condition
+		now       time.Time
+		want      bool
+	}{
+		{"dentro de la ventana", Condition{Start: "08:00", End: "18:00"}, at(monday, "08:00"), true},
+		{"el fin no se incluye", Condition{Start: "08:00", End: "18:00"}, at(monday, "18:00"), false},
+		{"antes de la ventana", Condition{Start: "08:00", End: "18:00"}, at(monday, "07:59"), false},
+		{"cruza la medianoche, antes", Condition{Start: "22:00", End: "06:00"}, at(monday, "23:30"), true},
+		{"cruza la medianoche, después", Condition{Start: "22:00", End: "06:00"}, at(tuesday, "05:59"), true},
+		{"cruza la medianoche, fuera", Condition{Start: "22:00", End: "06:00"}, at(tuesday, "06:00"), false},
+		{"cruza la medianoche, mediodía", Condition{Start: "22:00", End: "06:00"}, at(monday, "12:00"), false},
+		{"día abreviado", Condition{Start: "08:00", End: "18:00", Days: []string{"Mon"}}, at(monday, "09:00"), true},
+		{"día completo", Condition{Start: "08:00", End: "18:00", Days: []string{"tuesday"}}, at(tuesday, "09:00"), true},
+		{"otro día", Condition{Start: "08:00", End: "18:00", Days: []string{"mon", "wed"}}, at(tuesday, "09:00"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.condition.Type = ConditionTimeWindow
+			if err := tt.condition.Validate(); err != nil {
+				t.Fatalf("condición inválida: %v", err)
+			}
+			got, err := tt.condition.Evaluate(Facts{Now: tt.now})
+			if err != nil {
+				t.Fatalf("error inesperado: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Evaluate(%s) = %v, se esperaba %v", tt.now.Format("Mon 15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionValidateRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition Condition
+	}{
+		{"tipo desconocido", Condition{Type: "lunar_phase"}},
+		{"desconocido anidado en una rama", Any(Condition{Type: ConditionUserActive}, Not(Condition{Type: "typo"}))},
+		{"all vacío", All()},
+		{"any vacío", Any()},
+		{"not sin hijas", Condition{Type: ConditionNot}},
+		{"not con dos hijas", Condition{Type: ConditionNot, Conditions: []Condition{{Type: ConditionUserActive}, {Type: ConditionUserInactive}}}},
+		{"process_running sin proceso", Condition{Type: ConditionProcessRunning}},
+		{"hijas en una condición simple", Condition{Type: ConditionUserActive, Conditions: []Condition{{Type: ConditionUserInactive}}}},
+		{"hora inválida", Condition{Type: ConditionTimeWindow, Start: "08:00", End: "25:00"}},
+		{"sin hora de inicio", Condition{Type: ConditionTimeWindow, End: "18:00"}},
+		{"día en español", Condition{Type: ConditionTimeWindow, Start: "08:00", End: "18:00", Days: []string{"lunes"}}},
+		{"prefijo de día", Condition{Type: ConditionTimeWindow, Start: "08:00", End: "18:00", Days: []string{"mond"}}},
+		{"día de dos letras", Condition{Type: ConditionTimeWindow, Start: "08:00", End: "18:00", Days: []string{"mo"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.condition.Validate(); err == nil {
+				t.Errorf("Validate aceptó %+v", tt.condition)
+			}
+		})
+	}
+}
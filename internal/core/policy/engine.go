@@ -0,0 +1,245 @@
+package policy
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Verdict es la acción resultante para un objetivo (proceso o URL).
+type Verdict string
+
+const (
+	VerdictSuspend    Verdict = "suspend"
+	VerdictResume     Verdict = "resume"
+	VerdictBlockURL   Verdict = "block_url"
+	VerdictUnblockURL Verdict = "unblock_url"
+)
+
+// AllTargets representa a todos los objetivos configurados del tipo que
+// corresponda a la acción.
+const AllTargets = "*"
+
+// Action asocia un veredicto a una lista de objetivos. Los veredictos de
+// procesos aplican sobre nombres de proceso y los de URL sobre URLs.
+type Action struct {
+	Verdict Verdict  `json:"verdict"`
+	Targets []string `json:"targets"`
+}
+
+// Rule es una regla declarativa: si When se cumple se aplican sus acciones.
+type Rule struct {
+	Name    string    `json:"name"`
+	When    Condition `json:"when"`
+	Actions []Action  `json:"actions"`
+}
+
+// RuleSet es el conjunto ordenado de reglas de una campaña. Las reglas se
+// evalúan en orden y, ante un mismo objetivo, prevalece la última regla que
+// se cumpla.
+type RuleSet struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Targets son los objetivos sobre los que el motor debe emitir veredictos.
+type Targets struct {
+	Processes []string
+	URLs      []string
+}
+
+// Decision contiene el veredicto para cada objetivo.
+type Decision struct {
+	Processes map[string]Verdict
+	URLs      map[string]Verdict
+}
+
+// DefaultRuleSet reproduce el comportamiento histórico del monitor: fuera de
+// llamada se suspenden los aplicativos y se bloquean las URLs; en llamada o
+// con el usuario inactivo se libera todo.
+func DefaultRuleSet() RuleSet {
+	releaseAll := []Action{
+		{Verdict: VerdictResume, Targets: []string{AllTargets}},
+		{Verdict: VerdictUnblockURL, Targets: []string{AllTargets}},
+	}
+	return RuleSet{Rules: []Rule{
+		{
+			Name: "fuera-de-llamada",
+			When: All(Condition{Type: ConditionUserActive}, Not(Condition{Type: ConditionCallInProgress})),
+			Actions: []Action{
+				{Verdict: VerdictSuspend, Targets: []string{AllTargets}},
+				{Verdict: VerdictBlockURL, Targets: []string{AllTargets}},
+			},
+		},
+		{
+			Name:    "en-llamada",
+			When:    All(Condition{Type: ConditionUserActive}, Condition{Type: ConditionCallInProgress}),
+			Actions: releaseAll,
+		},
+		{
+			Name:    "usuario-inactivo",
+			When:    Condition{Type: ConditionUserInactive},
+			Actions: releaseAll,
+		},
+	}}
+}
+
+// overrideRules aplican las órdenes remotas de los supervisores. Se evalúan
+// después de las reglas de la campaña, por lo que prevalecen sobre ellas.
+var overrideRules = []Rule{
+	{
+		Name: "bloqueo-forzado",
+		When: Condition{Type: ConditionForcedBlock},
+		Actions: []Action{
+			{Verdict: VerdictSuspend, Targets: []string{AllTargets}},
+			{Verdict: VerdictBlockURL, Targets: []string{AllTargets}},
+		},
+	},
+	{
+		Name: "liberacion-forzada",
+		When: Condition{Type: ConditionForcedRelease},
+		Actions: []Action{
+			{Verdict: VerdictResume, Targets: []string{AllTargets}},
+			{Verdict: VerdictUnblockURL, Targets: []string{AllTargets}},
+		},
+	},
+}
+
+// Validate verifica que todas las reglas del conjunto estén bien formadas.
+// La validación es estructural: no depende de los hechos, por lo que detecta
+// errores en ramas que con ciertos hechos no se llegan a evaluar.
+func (rs RuleSet) Validate() error {
+	for _, rule := range rs.Rules {
+		if err := rule.When.Validate(); err != nil {
+			return fmt.Errorf("regla %q inválida: %v", rule.Name, err)
+		}
+		for _, action := range rule.Actions {
+			switch action.Verdict {
+			case VerdictSuspend, VerdictResume, VerdictBlockURL, VerdictUnblockURL:
+			default:
+				return fmt.Errorf("regla %q: veredicto desconocido %q", rule.Name, action.Verdict)
+			}
+		}
+	}
+	return nil
+}
+
+// Engine evalúa un RuleSet contra los hechos de cada ciclo.
+type Engine struct {
+	ruleSet RuleSet
+}
+
+// NewEngine crea un motor para el conjunto de reglas indicado. Si el conjunto
+// está vacío o es inválido se usa DefaultRuleSet.
+func NewEngine(ruleSet *RuleSet) (*Engine, error) {
+	if ruleSet == nil || len(ruleSet.Rules) == 0 {
+		return &Engine{ruleSet: DefaultRuleSet()}, nil
+	}
+	if err := ruleSet.Validate(); err != nil {
+		return &Engine{ruleSet: DefaultRuleSet()}, err
+	}
+	return &Engine{ruleSet: *ruleSet}, nil
+}
+
+// Uses indica si alguna regla del conjunto aplicado depende de una condición
+// del tipo indicado (p. ej. ConditionCallInProgress). No considera las
+// órdenes remotas.
+func (e *Engine) Uses(conditionType string) bool {
+	for _, rule := range e.ruleSet.Rules {
+		if rule.When.uses(conditionType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate calcula el veredicto de cada objetivo. Los objetivos que ninguna
+// regla menciona se liberan (resume/unblock_url). Una orden remota vigente
+// (Facts.Override) prevalece sobre todas las reglas.
+func (e *Engine) Evaluate(facts Facts, targets Targets) Decision {
+	decision := Decision{
+		Processes: make(map[string]Verdict, len(targets.Processes)),
+		URLs:      make(map[string]Verdict, len(targets.URLs)),
+	}
+	for _, name := range targets.Processes {
+		decision.Processes[name] = VerdictResume
+	}
+	for _, url := range targets.URLs {
+		decision.URLs[url] = VerdictUnblockURL
+	}
+
+	rules := append(append([]Rule(nil), e.ruleSet.Rules...), overrideRules...)
+	for _, rule := range rules {
+		matched, err := rule.When.Evaluate(facts)
+		if err != nil {
+			log.Printf("Error evaluando la regla %q: %v\n", rule.Name, err)
+			continue
+		}
+		if !matched {
+			continue
+		}
+		for _, action := range rule.Actions {
+			switch action.Verdict {
+			case VerdictSuspend, VerdictResume:
+				assign(decision.Processes, targets.Processes, action)
+			case VerdictBlockURL, VerdictUnblockURL:
+				assign(decision.URLs, targets.URLs, action)
+			}
+		}
+	}
+	return decision
+}
+
+// assign aplica el veredicto de la acción a los objetivos que menciona.
+func assign(verdicts map[string]Verdict, configured []string, action Action) {
+	for _, target := range action.Targets {
+		if target == AllTargets {
+			for _, name := range configured {
+				verdicts[name] = action.Verdict
+			}
+			continue
+		}
+		for _, name := range configured {
+			if strings.EqualFold(name, target) {
+				verdicts[name] = action.Verdict
+			}
+		}
+	}
+}
+
+// Diff retorna los veredictos que cambiaron respecto a la decisión anterior.
+// Los objetivos que desaparecieron y estaban bloqueados o suspendidos se
+// incluyen con el veredicto de liberación correspondiente.
+func (d Decision) Diff(previous Decision) Decision {
+	return Decision{
+		Processes: diffVerdicts(d.Processes, previous.Processes, VerdictSuspend, VerdictResume),
+		URLs:      diffVerdicts(d.URLs, previous.URLs, VerdictBlockURL, VerdictUnblockURL),
+	}
+}
+
+func diffVerdicts(current, previous map[string]Verdict, restrictive, release Verdict) map[string]Verdict {
+	changed := make(map[string]Verdict)
+	for target, verdict := range current {
+		if prev, ok := previous[target]; !ok || prev != verdict {
+			changed[target] = verdict
+		}
+	}
+	for target, prev := range previous {
+		if _, ok := current[target]; !ok && prev == restrictive {
+			changed[target] = release
+		}
+	}
+	return changed
+}
+
+// Filter retorna, ordenados, los objetivos con el veredicto indicado.
+func Filter(verdicts map[string]Verdict, verdict Verdict) []string {
+	var result []string
+	for target, v := range verdicts {
+		if v == verdict {
+			result = append(result, target)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,144 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+var targets = Targets{
+	Processes: []string{"cobis.exe", "cxc.exe"},
+	URLs:      []string{"youtube.com"},
+}
+
+func TestEngineEvaluateDefaultRuleSet(t *testing.T) {
+	engine, err := NewEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		facts   Facts
+		process Verdict
+		url     Verdict
+	}{
+		{"fuera de llamada", Facts{UserActive: true}, VerdictSuspend, VerdictBlockURL},
+		{"en llamada", Facts{UserActive: true, CallInProgress: true}, VerdictResume, VerdictUnblockURL},
+		{"usuario inactivo", Facts{}, VerdictResume, VerdictUnblockURL},
+		{"bloqueo forzado en llamada", Facts{UserActive: true, CallInProgress: true, Override: OverrideForceBlock}, VerdictSuspend, VerdictBlockURL},
+		{"bloqueo forzado con el usuario inactivo", Facts{Override: OverrideForceBlock}, VerdictSuspend, VerdictBlockURL},
+		{"liberación forzada fuera de llamada", Facts{UserActive: true, Override: OverrideForceRelease}, VerdictResume, VerdictUnblockURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := engine.Evaluate(tt.facts, targets)
+			for _, name := range targets.Processes {
+				if got := decision.Processes[name]; got != tt.process {
+					t.Errorf("%s = %s, se esperaba %s", name, got, tt.process)
+				}
+			}
+			if got := decision.URLs["youtube.com"]; got != tt.url {
+				t.Errorf("youtube.com = %s, se esperaba %s", got, tt.url)
+			}
+		})
+	}
+}
+
+func TestEngineEvaluateCampaignRules(t *testing.T) {
+	engine, err := NewEngine(&RuleSet{Rules: []Rule{
+		{
+			Name: "bloquear-todo",
+			When: Condition{Type: ConditionUserActive},
+			Actions: []Action{
+				{Verdict: VerdictSuspend, Targets: []string{AllTargets}},
+				{Verdict: VerdictBlockURL, Targets: []string{AllTargets}},
+			},
+		},
+		{
+			// Una regla posterior prevalece sobre la anterior para sus objetivos.
+			Name:    "cxc-con-servidor",
+			When:    Condition{Type: ConditionServerOnline},
+			Actions: []Action{{Verdict: VerdictResume, Targets: []string{"CXC.exe"}}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decision := engine.Evaluate(Facts{UserActive: true, ServerOnline: true}, targets)
+	want := map[string]Verdict{"cobis.exe": VerdictSuspend, "cxc.exe": VerdictResume}
+	if !reflect.DeepEqual(decision.Processes, want) {
+		t.Errorf("procesos = %v, se esperaba %v", decision.Processes, want)
+	}
+
+	// Los objetivos que ninguna regla menciona se liberan.
+	decision = engine.Evaluate(Facts{}, targets)
+	want = map[string]Verdict{"cobis.exe": VerdictResume, "cxc.exe": VerdictResume}
+	if !reflect.DeepEqual(decision.Processes, want) || decision.URLs["youtube.com"] != VerdictUnblockURL {
+		t.Errorf("decisión = %+v, se esperaba liberar todo", decision)
+	}
+
+	// La orden remota prevalece sobre la regla posterior de la campaña.
+	decision = engine.Evaluate(Facts{UserActive: true, ServerOnline: true, Override: OverrideForceBlock}, targets)
+	if decision.Processes["cxc.exe"] != VerdictSuspend {
+		t.Errorf("cxc.exe = %s, el bloqueo forzado debía prevalecer", decision.Processes["cxc.exe"])
+	}
+}
+
+func TestNewEngineRejectsInvalidRuleSet(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		// La rama inválida no se evaluaría con los hechos vacíos.
+		{"condición inválida en una rama", Rule{
+			When:    Any(Condition{Type: ConditionUserInactive}, Condition{Type: ConditionProcessRunning}),
+			Actions: []Action{{Verdict: VerdictResume, Targets: []string{AllTargets}}},
+		}},
+		{"veredicto desconocido", Rule{
+			When:    Condition{Type: ConditionUserActive},
+			Actions: []Action{{Verdict: "explode", Targets: []string{AllTargets}}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rule.Name = tt.name
+			engine, err := NewEngine(&RuleSet{Rules: []Rule{tt.rule}})
+			if err == nil {
+				t.Fatal("se esperaba un error")
+			}
+			// Se usa el conjunto por defecto.
+			if got := engine.Evaluate(Facts{UserActive: true}, targets).Processes["cobis.exe"]; got != VerdictSuspend {
+				t.Errorf("cobis.exe = %s, se esperaba el comportamiento por defecto", got)
+			}
+		})
+	}
+}
+
+func TestDecisionDiff(t *testing.T) {
+	previous := Decision{
+		Processes: map[string]Verdict{"cobis.exe": VerdictSuspend, "cxc.exe": VerdictResume, "gone.exe": VerdictSuspend, "idle.exe": VerdictResume},
+		URLs:      map[string]Verdict{"youtube.com": VerdictBlockURL, "old.com": VerdictBlockURL},
+	}
+	current := Decision{
+		Processes: map[string]Verdict{"cobis.exe": VerdictSuspend, "cxc.exe": VerdictSuspend, "new.exe": VerdictResume},
+		URLs:      map[string]Verdict{"youtube.com": VerdictUnblockURL},
+	}
+	diff := current.Diff(previous)
+
+	wantProcesses := map[string]Verdict{
+		"cxc.exe":  VerdictSuspend,
+		"new.exe":  VerdictResume,
+		"gone.exe": VerdictResume,
+	}
+	if !reflect.DeepEqual(diff.Processes, wantProcesses) {
+		t.Errorf("procesos = %v, se esperaba %v", diff.Processes, wantProcesses)
+	}
+	wantURLs := map[string]Verdict{"youtube.com": VerdictUnblockURL, "old.com": VerdictUnblockURL}
+	if !reflect.DeepEqual(diff.URLs, wantURLs) {
+		t.Errorf("URLs = %v, se esperaba %v", diff.URLs, wantURLs)
+	}
+
+	if diff := current.Diff(current); len(diff.Processes) != 0 || len(diff.URLs) != 0 {
+		t.Errorf("una decisión sin cambios produjo %+v", diff)
+	}
+}
@@ -1,158 +1,173 @@
 package services
 
 import (
-	"fmt"
-	"unsafe"
-
-	"github.com/fatih/color"
-	"golang.org/x/sys/windows"
+	"strings"
+	"sync"
+	"time"
 )
 
+// ProcessInfo identifica un proceso de forma independiente de la plataforma.
+// StartTime permite distinguir un proceso de otro que reutilice su PID.
+// SessionID es la sesión de Windows o la sesión de login en Linux; UID y
+// Publisher solo se informan en Linux y Windows respectivamente. Los demás
+// campos se informan si el proceso permite consultarlos.
 type ProcessInfo struct {
-	Name string
-	ID   uint32
+	Name        string
+	ID          uint32
+	StartTime   time.Time
+	SessionID   uint32
+	UID         uint32
+	Path        string
+	CommandLine string
+	ParentID    uint32
+	ParentName  string
+	Publisher   string
+}
+
+// SameProcess indica si ambos valores describen la misma instancia de proceso.
+func (p ProcessInfo) SameProcess(other ProcessInfo) bool {
+	return p.ID == other.ID && p.StartTime.Equal(other.StartTime)
 }
 
 type ApplicationManager interface {
-	SuspendProcess(handle windows.Handle) error
-	ResumeProcess(handle windows.Handle) error
+	SuspendProcess(process ProcessInfo) error
+	ResumeProcess(process ProcessInfo) error
+	TerminateProcess(process ProcessInfo) error
+	State(pid uint32) ProcessState
+	RetainHandles(processes []ProcessInfo)
+	HandleStats() HandleStats
 	ListApplicationsInCurrentSession() ([]ProcessInfo, error)
-	GetProcessHandlesInCurrentSession(processName string) ([]windows.Handle, error)
-	Intersect(a, b []ProcessInfo) []ProcessInfo
+	ListApplicationsInSession(session Session) ([]ProcessInfo, error)
+	// DescribeProcesses completa la ruta, la línea de comandos y el editor de
+	// los procesos indicados. Los listados solo garantizan nombre, PID, padre,
+	// sesión y hora de creación, para no abrir cada proceso del sistema.
+	DescribeProcesses(processes []ProcessInfo)
+	GetProcessesInCurrentSession(processName string) ([]ProcessInfo, error)
 	EqualProcessSlices(a, b []ProcessInfo) bool
 }
 
-type windowsApplicationManager struct {
-	systemManager SystemManager
+// ProcessState es el estado de suspensión que el ApplicationManager conoce de
+// un PID. Refs cuenta las restricciones vigentes sobre el proceso (p. ej. si
+// es raíz de un grupo y a la vez descendiente de otro); se desea suspendido
+// mientras Refs sea mayor que cero.
+type ProcessState struct {
+	Tracked   bool
+	Refs      int
+	Desired   bool
+	Suspended bool
 }
 
-func NewWindowsApplicationManager(systemManager SystemManager) ApplicationManager {
-	return &windowsApplicationManager{systemManager: systemManager}
+// suspendTracker lleva el estado deseado y real de cada PID para que
+// SuspendProcess y ResumeProcess solo emitan transiciones. La suspensión del
+// sistema es acumulativa (NtSuspendProcess incrementa un contador): suspender
+// dos veces y reanudar una deja el proceso congelado, por eso nunca se
+// suspende un proceso que ya está suspendido.
+type suspendTracker struct {
+	mu      sync.Mutex
+	entries map[uint32]*trackedProcess
 }
 
-func (am *windowsApplicationManager) SuspendProcess(handle windows.Handle) error {
-	color.Red("Suspendiendo proceso")
-	ntSuspendProcess := windows.NewLazySystemDLL("ntdll.dll").NewProc("NtSuspendProcess")
-	r1, _, e1 := ntSuspendProcess.Call(uintptr(handle))
-	if r1 != 0 {
-		if e1 != nil && e1 != windows.ERROR_SUCCESS {
-			return fmt.Errorf("failed to suspend process: %v", e1)
-		}
+type trackedProcess struct {
+	process   ProcessInfo
+	refs      int
+	suspended bool
+}
+
+func newSuspendTracker() suspendTracker {
+	return suspendTracker{entries: make(map[uint32]*trackedProcess)}
+}
+
+// entryLocked retorna el registro del proceso, descartando el de un proceso
+// anterior que tenía el mismo PID.
+func (t *suspendTracker) entryLocked(process ProcessInfo) *trackedProcess {
+	entry := t.entries[process.ID]
+	if entry != nil && !process.StartTime.IsZero() && !entry.process.StartTime.IsZero() && !entry.process.SameProcess(process) {
+		entry = nil
 	}
-	return nil
+	if entry == nil {
+		entry = &trackedProcess{process: process}
+		t.entries[process.ID] = entry
+	}
+	return entry
 }
 
-func (am *windowsApplicationManager) ResumeProcess(handle windows.Handle) error {
-	color.Green("Reanudando proceso")
-	ntResumeProcess := windows.NewLazySystemDLL("ntdll.dll").NewProc("NtResumeProcess")
-	r1, _, e1 := ntResumeProcess.Call(uintptr(handle))
-	if r1 != 0 {
-		if e1 != nil && e1 != windows.ERROR_SUCCESS {
-			return fmt.Errorf("failed to resume process: %v", e1)
+// suspend suma una restricción al proceso y lo suspende con transition si aún
+// no lo está. Si la suspensión falla no se suma la restricción: quien llama
+// debe volver a pedirla.
+func (t *suspendTracker) suspend(process ProcessInfo, transition func() error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry := t.entryLocked(process)
+	if !entry.suspended {
+		if err := transition(); err != nil {
+			if entry.refs == 0 {
+				delete(t.entries, process.ID)
+			}
+			return err
 		}
+		entry.suspended = true
 	}
+	entry.refs++
 	return nil
 }
 
-func (am *windowsApplicationManager) ListApplicationsInCurrentSession() ([]ProcessInfo, error) {
-	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
-	if err != nil {
-		return nil, err
+// resume quita una restricción y reanuda el proceso cuando ya no queda
+// ninguna. Un proceso que no se suspendió en esta ejecución (p. ej. al
+// recuperar el registro tras un cierre inesperado) se reanuda directamente.
+func (t *suspendTracker) resume(process ProcessInfo, transition func() error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, tracked := t.entries[process.ID]
+	if !tracked || (!process.StartTime.IsZero() && !entry.process.StartTime.IsZero() && !entry.process.SameProcess(process)) {
+		return transition()
 	}
-	defer windows.CloseHandle(snapshot)
-
-	var entry windows.ProcessEntry32
-	entry.Size = uint32(unsafe.Sizeof(entry))
-	var apps []ProcessInfo
-
-	currentSessionID, err := am.systemManager.GetCurrentSessionID()
-	if err != nil {
-		return nil, err
+	if entry.refs > 0 {
+		entry.refs--
 	}
-
-	for {
-		err = windows.Process32Next(snapshot, &entry)
-		if err != nil {
-			break
-		}
-		var sessionID uint32
-		err = windows.ProcessIdToSessionId(uint32(entry.ProcessID), &sessionID)
-		if err != nil {
-			continue
-		}
-		if sessionID == currentSessionID {
-			apps = append(apps, ProcessInfo{Name: windows.UTF16ToString(entry.ExeFile[:]), ID: uint32(entry.ProcessID)})
-		}
+	if entry.refs > 0 {
+		return nil
 	}
-
-	if len(apps) == 0 {
-		return nil, fmt.Errorf("no applications found in the current session")
+	delete(t.entries, process.ID)
+	if !entry.suspended {
+		return nil
 	}
-
-	return apps, nil
+	return transition()
 }
 
-func (am *windowsApplicationManager) GetProcessHandlesInCurrentSession(processName string) ([]windows.Handle, error) {
-	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
-	if err != nil {
-		return nil, err
+// State retorna el estado conocido del PID.
+func (t *suspendTracker) State(pid uint32) ProcessState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	entry, ok := t.entries[pid]
+	if !ok {
+		return ProcessState{}
 	}
-	defer windows.CloseHandle(snapshot)
-
-	var entry windows.ProcessEntry32
-	entry.Size = uint32(unsafe.Sizeof(entry))
-	var handles []windows.Handle
+	return ProcessState{Tracked: true, Refs: entry.refs, Desired: entry.refs > 0, Suspended: entry.suspended}
+}
 
-	currentSessionID, err := am.systemManager.GetCurrentSessionID()
-	if err != nil {
-		return nil, err
-	}
+// HandleStats resume el uso de la caché de manejadores de proceso.
+type HandleStats struct {
+	Open   int    `json:"open"`
+	Opened uint64 `json:"opened"`
+	Reused uint64 `json:"reused"`
+	Closed uint64 `json:"closed"`
+}
 
-	for {
-		err = windows.Process32Next(snapshot, &entry)
-		if err != nil {
-			break
-		}
-		if windows.UTF16ToString(entry.ExeFile[:]) == processName {
-			var sessionID uint32
-			err := windows.ProcessIdToSessionId(uint32(entry.ProcessID), &sessionID)
-			if err != nil {
-				continue
-			}
-			if sessionID == currentSessionID {
-				handle, err := windows.OpenProcess(windows.PROCESS_SUSPEND_RESUME|windows.PROCESS_QUERY_INFORMATION, false, entry.ProcessID)
-				if err != nil {
-					fmt.Printf("Error opening process %d: %v\n", entry.ProcessID, err)
-					continue
-				}
-				fmt.Printf("Found process %s (PID: %d) in current session.\n", processName, entry.ProcessID)
-				handles = append(handles, handle)
-			}
-		}
-	}
+// noProcessHandles implementa la caché de manejadores en las plataformas que
+// no mantienen manejadores abiertos.
+type noProcessHandles struct{}
 
-	if len(handles) == 0 {
-		return nil, fmt.Errorf("no instances of process %s found in the current session", processName)
-	}
+func (noProcessHandles) RetainHandles(processes []ProcessInfo) {}
 
-	return handles, nil
+func (noProcessHandles) HandleStats() HandleStats {
+	return HandleStats{}
 }
 
-func (am *windowsApplicationManager) Intersect(a, b []ProcessInfo) []ProcessInfo {
-	m := make(map[string]bool)
-	for _, item := range b {
-		m[item.Name] = true
-	}
-	var result []ProcessInfo
-	for _, item := range a {
-		if _, ok := m[item.Name]; ok {
-			result = append(result, item)
-		}
-	}
-	return result
-}
+// processSetOps implementa las operaciones sobre listas de procesos que no
+// dependen de la plataforma; las implementaciones de ApplicationManager la embeben.
+type processSetOps struct{}
 
-func (am *windowsApplicationManager) EqualProcessSlices(a, b []ProcessInfo) bool {
+func (processSetOps) EqualProcessSlices(a, b []ProcessInfo) bool {
 	if len(a) != len(b) {
 		return false
 	}
@@ -161,9 +176,28 @@ func (am *windowsApplicationManager) EqualProcessSlices(a, b []ProcessInfo) bool
 		m[item.ID] = item
 	}
 	for _, item := range b {
-		if _, ok := m[item.ID]; !ok {
+		if prev, ok := m[item.ID]; !ok || !prev.SameProcess(item) {
 			return false
 		}
 	}
 	return true
 }
+
+// filterByName retorna los procesos con el nombre indicado, sin distinguir
+// mayúsculas.
+func filterByName(processes []ProcessInfo, processName string) []ProcessInfo {
+	var result []ProcessInfo
+	for _, process := range processes {
+		if strings.EqualFold(process.Name, processName) {
+			result = append(result, process)
+		}
+	}
+	return result
+}
+
+// resolveParentNames completa ParentName a partir de la misma tabla de procesos.
+func resolveParentNames(processes []ProcessInfo, names map[uint32]string) {
+	for i := range processes {
+		processes[i].ParentName = names[processes[i].ParentID]
+	}
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// clockTicks es USER_HZ, fijo en 100 en todas las arquitecturas de Linux soportadas.
+	clockTicks = 100
+	// pfKthread marca los hilos del kernel en el campo flags de /proc/<pid>/stat.
+	pfKthread = 0x00200000
+)
+
+var errKernelThread = fmt.Errorf("hilo del kernel")
+
+type linuxApplicationManager struct {
+	processSetOps
+	suspendTracker
+	noProcessHandles
+	systemManager SystemManager
+	procRoot      string
+}
+
+// NewLinuxApplicationManager crea un ApplicationManager que enumera /proc y
+// suspende/reanuda con SIGSTOP/SIGCONT los procesos del usuario actual.
+func NewLinuxApplicationManager(systemManager SystemManager) ApplicationManager {
+	return &linuxApplicationManager{suspendTracker: newSuspendTracker(), systemManager: systemManager, procRoot: "/proc"}
+}
+
+// NewApplicationManager crea el ApplicationManager de la plataforma actual.
+func NewApplicationManager(systemManager SystemManager) ApplicationManager {
+	return NewLinuxApplicationManager(systemManager)
+}
+
+func (am *linuxApplicationManager) SuspendProcess(process ProcessInfo) error {
+	color.Red("Suspendiendo proceso")
+	return am.suspend(process, func() error { return am.signal(process, syscall.SIGSTOP) })
+}
+
+func (am *linuxApplicationManager) ResumeProcess(process ProcessInfo) error {
+	color.Green("Reanudando proceso")
+	return am.resume(process, func() error { return am.signal(process, syscall.SIGCONT) })
+}
+
+func (am *linuxApplicationManager) TerminateProcess(process ProcessInfo) error {
+	color.Red("Terminando proceso")
+	return am.signal(process, syscall.SIGTERM)
+}
+
+// signal envía la señal si el PID sigue correspondiendo a la misma instancia.
+func (am *linuxApplicationManager) signal(process ProcessInfo, sig syscall.Signal) error {
+	current, err := am.readProcess(process.ID)
+	if err != nil {
+		return fmt.Errorf("error reading process %d: %v", process.ID, err)
+	}
+	if !process.StartTime.IsZero() && !current.SameProcess(process) {
+		return fmt.Errorf("process %d is no longer %s (PID reused)", process.ID, process.Name)
+	}
+	if err := syscall.Kill(int(process.ID), sig); err != nil {
+		return fmt.Errorf("failed to send %v to process %d: %v", sig, process.ID, err)
+	}
+	return nil
+}
+
+func (am *linuxApplicationManager) ListApplicationsInCurrentSession() ([]ProcessInfo, error) {
+	apps, err := am.listApplications(uint32(os.Getuid()))
+	if err != nil {
+		return nil, err
+	}
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no applications found in the current session")
+	}
+	return apps, nil
+}
+
+// ListApplicationsInSession enumera los procesos del usuario de la sesión.
+// Requiere ejecutarse como root para ver los procesos de otros usuarios.
+func (am *linuxApplicationManager) ListApplicationsInSession(session Session) ([]ProcessInfo, error) {
+	return am.listApplications(session.UID)
+}
+
+// DescribeProcesses no hace nada en Linux: /proc ya entrega todos los datos
+// al listar sin costo apreciable.
+func (am *linuxApplicationManager) DescribeProcesses(processes []ProcessInfo) {}
+
+// listApplications enumera los procesos del UID indicado.
+func (am *linuxApplicationManager) listApplications(uid uint32) ([]ProcessInfo, error) {
+	entries, err := os.ReadDir(am.procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	self := uint32(os.Getpid())
+	names := make(map[uint32]string)
+	var apps []ProcessInfo
+	for _, entry := range entries {
+		pid, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil || uint32(pid) == self {
+			continue
+		}
+		process, err := am.readProcess(uint32(pid))
+		if err != nil {
+			continue
+		}
+		names[process.ID] = process.Name
+		if process.UID == uid {
+			apps = append(apps, process)
+		}
+	}
+	resolveParentNames(apps, names)
+	return apps, nil
+}
+
+func (am *linuxApplicationManager) GetProcessesInCurrentSession(processName string) ([]ProcessInfo, error) {
+	apps, err := am.ListApplicationsInCurrentSession()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := filterByName(apps, processName)
+	if len(processes) == 0 {
+		return nil, fmt.Errorf("no instances of process %s found in the current session", processName)
+	}
+	return processes, nil
+}
+
+// readProcess arma la identidad del proceso a partir de /proc/<pid>.
+func (am *linuxApplicationManager) readProcess(pid uint32) (ProcessInfo, error) {
+	dir := filepath.Join(am.procRoot, strconv.FormatUint(uint64(pid), 10))
+
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	// El nombre (comm) va entre paréntesis y puede contener espacios.
+	open, close := strings.IndexByte(string(stat), '('), strings.LastIndexByte(string(stat), ')')
+	if open < 0 || close < open {
+		return ProcessInfo{}, fmt.Errorf("formato inesperado en %s/stat", dir)
+	}
+	fields := strings.Fields(string(stat[close+1:]))
+	if len(fields) < 20 {
+		return ProcessInfo{}, fmt.Errorf("formato inesperado en %s/stat", dir)
+	}
+	if flags, err := strconv.ParseUint(fields[6], 10, 64); err == nil && flags&pfKthread != 0 {
+		return ProcessInfo{}, errKernelThread
+	}
+	startTicks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return ProcessInfo{}, err
+	}
+	parentID, _ := strconv.ParseUint(fields[1], 10, 32)
+
+	process := ProcessInfo{
+		Name:      string(stat[open+1 : close]),
+		ID:        pid,
+		StartTime: am.bootTime().Add(time.Duration(startTicks) * time.Second / clockTicks),
+		ParentID:  uint32(parentID),
+	}
+	// comm se trunca a 15 caracteres; el ejecutable da el nombre completo.
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil {
+		process.Path = strings.TrimSuffix(exe, " (deleted)")
+		process.Name = filepath.Base(process.Path)
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		process.CommandLine = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+	}
+	if uid, err := readStatusUID(filepath.Join(dir, "status")); err == nil {
+		process.UID = uid
+	}
+	if raw, err := os.ReadFile(filepath.Join(dir, "sessionid")); err == nil {
+		if session, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 32); err == nil {
+			process.SessionID = uint32(session)
+		}
+	}
+	return process, nil
+}
+
+var (
+	bootTimeOnce  sync.Once
+	bootTimeValue time.Time
+)
+
+// bootTime retorna la hora de arranque del sistema (btime en /proc/stat).
+func (am *linuxApplicationManager) bootTime() time.Time {
+	bootTimeOnce.Do(func() {
+		content, err := os.ReadFile(filepath.Join(am.procRoot, "stat"))
+		if err != nil {
+			return
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if strings.HasPrefix(line, "btime ") {
+				if seconds, err := strconv.ParseInt(strings.TrimSpace(line[6:]), 10, 64); err == nil {
+					bootTimeValue = time.Unix(seconds, 0)
+				}
+				return
+			}
+		}
+	})
+	return bootTimeValue
+}
+
+// readStatusUID retorna el UID real informado en /proc/<pid>/status.
+func readStatusUID(path string) (uint32, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "Uid:") {
+			fields := strings.Fields(line[4:])
+			if len(fields) == 0 {
+				break
+			}
+			uid, err := strconv.ParseUint(fields[0], 10, 32)
+			return uint32(uid), err
+		}
+	}
+	return 0, fmt.Errorf("UID no encontrado en %s", path)
+}
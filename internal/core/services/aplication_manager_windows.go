@@ -0,0 +1,328 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/fatih/color"
+	"golang.org/x/sys/windows"
+)
+
+type windowsApplicationManager struct {
+	processSetOps
+	suspendTracker
+	systemManager SystemManager
+	handles       *processHandleCache
+
+	publishersMu sync.Mutex
+	publishers   map[string]string
+
+	detailsMu sync.Mutex
+	details   map[uint32]processDetails
+}
+
+// processDetails son los datos de un proceso que requieren abrirlo. Se
+// guardan por PID junto con la hora de creación para descartarlos si el PID
+// se reutiliza.
+type processDetails struct {
+	startTime   time.Time
+	path        string
+	commandLine string
+	publisher   string
+	usedAt      time.Time
+}
+
+// processDetailsTTL es cuánto se conservan los datos de un proceso que no se
+// volvió a describir (normalmente porque terminó).
+const processDetailsTTL = 5 * time.Minute
+
+func NewWindowsApplicationManager(systemManager SystemManager) ApplicationManager {
+	return &windowsApplicationManager{
+		suspendTracker: newSuspendTracker(),
+		systemManager:  systemManager,
+		handles:        newProcessHandleCache(),
+		publishers:     make(map[string]string),
+		details:        make(map[uint32]processDetails),
+	}
+}
+
+// NewApplicationManager crea el ApplicationManager de la plataforma actual.
+func NewApplicationManager(systemManager SystemManager) ApplicationManager {
+	return NewWindowsApplicationManager(systemManager)
+}
+
+func (am *windowsApplicationManager) SuspendProcess(process ProcessInfo) error {
+	color.Red("Suspendiendo proceso")
+	return am.suspend(process, func() error { return am.callOnProcess(process, "NtSuspendProcess") })
+}
+
+func (am *windowsApplicationManager) ResumeProcess(process ProcessInfo) error {
+	color.Green("Reanudando proceso")
+	return am.resume(process, func() error { return am.callOnProcess(process, "NtResumeProcess") })
+}
+
+func (am *windowsApplicationManager) TerminateProcess(process ProcessInfo) error {
+	color.Red("Terminando proceso")
+	err := am.withProcess(process, windows.PROCESS_TERMINATE, func(handle windows.Handle) error {
+		if err := windows.TerminateProcess(handle, 1); err != nil {
+			return fmt.Errorf("TerminateProcess failed for process %d: %v", process.ID, err)
+		}
+		return nil
+	})
+	if err == nil {
+		am.handles.evict(process)
+	}
+	return err
+}
+
+// callOnProcess invoca sobre el proceso la función indicada de ntdll.
+func (am *windowsApplicationManager) callOnProcess(process ProcessInfo, procName string) error {
+	return am.withProcess(process, windows.PROCESS_SUSPEND_RESUME, func(handle windows.Handle) error {
+		proc := windows.NewLazySystemDLL("ntdll.dll").NewProc(procName)
+		r1, _, e1 := proc.Call(uintptr(handle))
+		if r1 != 0 {
+			if e1 != nil && e1 != windows.ERROR_SUCCESS {
+				return fmt.Errorf("%s failed for process %d: %v", procName, process.ID, e1)
+			}
+		}
+		return nil
+	})
+}
+
+// withProcess ejecuta fn con un manejador del proceso. Si se conoce la hora de
+// creación se usa la caché de manejadores, que ya verificó que el PID no fue
+// reutilizado; si no, se abre un manejador con el acceso indicado solo para
+// esta llamada.
+func (am *windowsApplicationManager) withProcess(process ProcessInfo, access uint32, fn func(windows.Handle) error) error {
+	if !process.StartTime.IsZero() {
+		handle, err := am.handles.get(process)
+		if err != nil {
+			return err
+		}
+		return fn(handle)
+	}
+
+	handle, err := windows.OpenProcess(access|windows.PROCESS_QUERY_LIMITED_INFORMATION, false, process.ID)
+	if err != nil {
+		return fmt.Errorf("error opening process %d: %v", process.ID, err)
+	}
+	defer windows.CloseHandle(handle)
+	return fn(handle)
+}
+
+// RetainHandles cierra los manejadores cacheados de los procesos que
+// terminaron o que ya no coinciden con la configuración, salvo los que siguen
+// suspendidos y deberán reanudarse.
+func (am *windowsApplicationManager) RetainHandles(processes []ProcessInfo) {
+	am.handles.retain(processes, func(pid uint32) bool { return am.State(pid).Tracked })
+}
+
+func (am *windowsApplicationManager) HandleStats() HandleStats {
+	return am.handles.snapshot()
+}
+
+func (am *windowsApplicationManager) ListApplicationsInCurrentSession() ([]ProcessInfo, error) {
+	currentSessionID, err := am.systemManager.GetCurrentSessionID()
+	if err != nil {
+		return nil, err
+	}
+	apps, err := am.listApplications(currentSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(apps) == 0 {
+		return nil, fmt.Errorf("no applications found in the current session")
+	}
+	return apps, nil
+}
+
+// ListApplicationsInSession enumera los procesos de otra sesión de Windows.
+// Requiere SeDebugPrivilege para consultar los procesos de otros usuarios.
+func (am *windowsApplicationManager) ListApplicationsInSession(session Session) ([]ProcessInfo, error) {
+	return am.listApplications(session.ID)
+}
+
+// listApplications enumera los procesos de la sesión con
+// NtQuerySystemInformation, que entrega nombre, padre, sesión y hora de
+// creación de todos los procesos sin abrir ninguno.
+func (am *windowsApplicationManager) listApplications(targetSessionID uint32) ([]ProcessInfo, error) {
+	buf := make([]byte, 512*1024)
+	for {
+		var size uint32
+		err := windows.NtQuerySystemInformation(windows.SystemProcessInformation, unsafe.Pointer(&buf[0]), uint32(len(buf)), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.STATUS_INFO_LENGTH_MISMATCH {
+			return nil, fmt.Errorf("error enumerando los procesos: %v", err)
+		}
+		if size <= uint32(len(buf)) {
+			size = uint32(len(buf)) * 2
+		}
+		buf = make([]byte, size)
+	}
+
+	names := make(map[uint32]string)
+	var apps []ProcessInfo
+	for offset := uint32(0); ; {
+		entry := (*windows.SYSTEM_PROCESS_INFORMATION)(unsafe.Pointer(&buf[offset]))
+		pid := uint32(entry.UniqueProcessID)
+		name := entry.ImageName.String()
+		names[pid] = name
+		if pid != 0 && entry.SessionID == targetSessionID {
+			apps = append(apps, ProcessInfo{
+				Name:      name,
+				ID:        pid,
+				StartTime: filetimeToTime(entry.CreateTime),
+				SessionID: entry.SessionID,
+				ParentID:  uint32(entry.InheritedFromUniqueProcessID),
+			})
+		}
+		if entry.NextEntryOffset == 0 {
+			break
+		}
+		offset += entry.NextEntryOffset
+	}
+	resolveParentNames(apps, names)
+	return apps, nil
+}
+
+// filetimeToTime convierte un FILETIME de 64 bits.
+func filetimeToTime(value int64) time.Time {
+	filetime := windows.Filetime{LowDateTime: uint32(value), HighDateTime: uint32(value >> 32)}
+	return time.Unix(0, filetime.Nanoseconds())
+}
+
+// DescribeProcesses completa los datos de los procesos. Cada proceso se abre
+// una sola vez en su vida: luego se usan los datos guardados por PID y hora
+// de creación.
+func (am *windowsApplicationManager) DescribeProcesses(processes []ProcessInfo) {
+	am.detailsMu.Lock()
+	defer am.detailsMu.Unlock()
+
+	now := time.Now()
+	for i := range processes {
+		process := &processes[i]
+		details, ok := am.details[process.ID]
+		if !ok || !details.startTime.Equal(process.StartTime) {
+			details = am.describeProcess(*process)
+		}
+		details.usedAt = now
+		am.details[process.ID] = details
+		process.Path = details.path
+		process.CommandLine = details.commandLine
+		process.Publisher = details.publisher
+	}
+	for pid, details := range am.details {
+		if now.Sub(details.usedAt) > processDetailsTTL {
+			delete(am.details, pid)
+		}
+	}
+}
+
+func (am *windowsApplicationManager) GetProcessesInCurrentSession(processName string) ([]ProcessInfo, error) {
+	apps, err := am.ListApplicationsInCurrentSession()
+	if err != nil {
+		return nil, err
+	}
+
+	processes := filterByName(apps, processName)
+	if len(processes) == 0 {
+		return nil, fmt.Errorf("no instances of process %s found in the current session", processName)
+	}
+	for _, process := range processes {
+		fmt.Printf("Found process %s (PID: %d) in current session.\n", processName, process.ID)
+	}
+	return processes, nil
+}
+
+// describeProcess abre el proceso y consulta la ruta de la imagen, la línea
+// de comandos y el editor. Los datos que no se pueden consultar (p. ej.
+// procesos protegidos) quedan vacíos.
+func (am *windowsApplicationManager) describeProcess(process ProcessInfo) processDetails {
+	details := processDetails{startTime: process.StartTime}
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, process.ID)
+	if err != nil {
+		return details
+	}
+	defer windows.CloseHandle(handle)
+
+	if path, err := processImagePath(handle); err == nil {
+		details.path = path
+		details.publisher = am.publisherOf(path)
+	}
+	if cmdline, err := processCommandLine(handle); err == nil {
+		details.commandLine = cmdline
+	}
+	return details
+}
+
+func processImagePath(handle windows.Handle) (string, error) {
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(handle, 0, &buf[0], &size); err != nil {
+		return "", err
+	}
+	return windows.UTF16ToString(buf[:size]), nil
+}
+
+// processCommandLine usa ProcessCommandLineInformation (Windows 8.1 o
+// posterior), que no requiere leer la memoria del proceso.
+func processCommandLine(handle windows.Handle) (string, error) {
+	var size uint32
+	windows.NtQueryInformationProcess(handle, windows.ProcessCommandLineInformation, nil, 0, &size)
+	if size == 0 {
+		return "", fmt.Errorf("línea de comandos no disponible")
+	}
+	buf := make([]byte, size)
+	if err := windows.NtQueryInformationProcess(handle, windows.ProcessCommandLineInformation, unsafe.Pointer(&buf[0]), size, &size); err != nil {
+		return "", err
+	}
+	return (*windows.NTUnicodeString)(unsafe.Pointer(&buf[0])).String(), nil
+}
+
+// publisherOf retorna el CompanyName de la información de versión del
+// ejecutable. Se guarda por ruta porque leerlo en cada ciclo es costoso.
+func (am *windowsApplicationManager) publisherOf(path string) string {
+	am.publishersMu.Lock()
+	defer am.publishersMu.Unlock()
+	if publisher, ok := am.publishers[path]; ok {
+		return publisher
+	}
+	publisher := fileCompanyName(path)
+	am.publishers[path] = publisher
+	return publisher
+}
+
+func fileCompanyName(path string) string {
+	size, err := windows.GetFileVersionInfoSize(path, nil)
+	if err != nil || size == 0 {
+		return ""
+	}
+	info := make([]byte, size)
+	if err := windows.GetFileVersionInfo(path, 0, size, unsafe.Pointer(&info[0])); err != nil {
+		return ""
+	}
+
+	var translation *[2]uint16
+	var length uint32
+	if err := windows.VerQueryValue(unsafe.Pointer(&info[0]), `\VarFileInfo\Translation`, unsafe.Pointer(&translation), &length); err != nil || length < 4 {
+		return ""
+	}
+	subBlock := fmt.Sprintf(`\StringFileInfo\%04x%04x\CompanyName`, translation[0], translation[1])
+	var value *uint16
+	if err := windows.VerQueryValue(unsafe.Pointer(&info[0]), subBlock, unsafe.Pointer(&value), &length); err != nil || length == 0 {
+		return ""
+	}
+	return windows.UTF16PtrToString(value)
+}
+
+func processStartTime(handle windows.Handle) (time.Time, error) {
+	var creation, exit, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, creation.Nanoseconds()), nil
+}
@@ -6,11 +6,22 @@ import (
 	"io/ioutil"
 	"net/http"
 	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/policy"
 )
 
 type ConfigResponse struct {
-	ProcessesToMonitor []string `json:"processes"`
-	UrlsToBlock        []string `json:"urls"`
+	ProcessesToMonitor []string                 `json:"processes"`
+	ProcessSelectors   []domain.ProcessSelector `json:"process_selectors,omitempty"`
+	UrlsToBlock        []string                 `json:"urls"`
+	Policy             *policy.RuleSet          `json:"policy,omitempty"`
+	DetectionRules     []domain.DetectionRule   `json:"detection_rules,omitempty"`
+	Subdomains         []string                 `json:"subdomains,omitempty"`
+	BlockingBackend    string                   `json:"blocking_backend,omitempty"`
+	DNSListen          string                   `json:"dns_listen,omitempty"`
+	DNSUpstream        string                   `json:"dns_upstream,omitempty"`
+	ProxyListen        string                   `json:"proxy_listen,omitempty"`
 }
 
 func FetchConfiguration(cliente string) (ConfigResponse, error) {
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAuditMemory es la cantidad de entradas recientes que se conservan en
+	// memoria para el volcado de diagnóstico.
+	maxAuditMemory = 50
+	// maxAuditLogSize es el tamaño a partir del cual el archivo se rota a
+	// audit.log.1, reemplazando la rotación anterior.
+	maxAuditLogSize = 1 << 20
+)
+
+// AuditEntry es una orden remota recibida y su resultado.
+type AuditEntry struct {
+	At              time.Time `json:"at"`
+	MessageID       string    `json:"message_id,omitempty"`
+	Command         string    `json:"command"`
+	IssuedBy        string    `json:"issued_by,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+	User            string    `json:"user,omitempty"`
+	DurationMinutes int       `json:"duration_minutes,omitempty"`
+	Result          string    `json:"result"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// Resultados posibles de una entrada de auditoría.
+const (
+	AuditApplied  = "applied"
+	AuditRejected = "rejected"
+	AuditExpired  = "expired"
+)
+
+// AuditLog registra las órdenes remotas en un archivo JSON Lines, una
+// entrada por línea, para poder reconstruir quién ordenó qué y cuándo.
+//
+// Es un registro local de apoyo: el registro autoritativo es el que el
+// servidor arma con los ack y nack de cada orden, ya que un usuario con
+// privilegios sobre el equipo puede editar este archivo.
+type AuditLog struct {
+	path     string
+	fallback string
+	mu       sync.Mutex
+	recent   []AuditEntry
+}
+
+// DefaultAuditLogPath retorna la ubicación del registro de auditoría en la
+// carpeta de datos del equipo (ProgramData en Windows, /var/lib en Linux),
+// fuera de la configuración del usuario auditado.
+func DefaultAuditLogPath() string {
+	return filepath.Join(machineDataDir(), "audit.log")
+}
+
+// fallbackAuditLogPath se usa si no se puede escribir en la carpeta del
+// equipo (p. ej. el agente corre sin privilegios en Linux).
+func fallbackAuditLogPath() string {
+	return filepath.Join(filepath.Dir(DefaultLedgerPath()), "audit.log")
+}
+
+var defaultAuditLog = &AuditLog{path: DefaultAuditLogPath(), fallback: fallbackAuditLogPath()}
+
+// NewAuditLog crea un registro de auditoría en la ruta indicada.
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record agrega la entrada al archivo y a las recientes. Si no se puede
+// escribir en la ruta principal se pasa a la alternativa.
+func (a *AuditLog) Record(entry AuditEntry) error {
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error serializando la entrada de auditoría: %v", err)
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.recent = append(a.recent, entry)
+	if excess := len(a.recent) - maxAuditMemory; excess > 0 {
+		a.recent = a.recent[excess:]
+	}
+
+	err = appendAuditLine(a.path, line)
+	if err != nil && a.fallback != "" && a.fallback != a.path {
+		log.Printf("No se puede escribir la auditoría en %s (%v), se usa %s\n", a.path, err, a.fallback)
+		a.path, a.fallback = a.fallback, ""
+		err = appendAuditLine(a.path, line)
+	}
+	return err
+}
+
+// appendAuditLine agrega la línea al archivo, rotándolo antes si con ella
+// superaría maxAuditLogSize.
+func appendAuditLine(path string, line []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creando la carpeta del registro de auditoría: %v", err)
+	}
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(line)) > maxAuditLogSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return fmt.Errorf("error rotando el registro de auditoría: %v", err)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error abriendo el registro de auditoría: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("error escribiendo el registro de auditoría: %v", err)
+	}
+	return nil
+}
+
+// Recent retorna las últimas entradas registradas desde el inicio del agente.
+func (a *AuditLog) Recent() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AuditEntry(nil), a.recent...)
+}
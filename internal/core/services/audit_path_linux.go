@@ -0,0 +1,6 @@
+package services
+
+// machineDataDir es la carpeta de datos del agente común a todo el equipo.
+func machineDataDir() string {
+	return "/var/lib/scrapeblocker"
+}
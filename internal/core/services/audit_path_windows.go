@@ -0,0 +1,15 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// machineDataDir es la carpeta de datos del agente común a todo el equipo.
+func machineDataDir() string {
+	dir := os.Getenv("ProgramData")
+	if dir == "" {
+		dir = `C:\ProgramData`
+	}
+	return filepath.Join(dir, "ScrapeBlocker")
+}
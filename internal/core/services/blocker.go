@@ -0,0 +1,142 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Backends de bloqueo de URLs seleccionables desde la configuración.
+const (
+	BlockingBackendHosts = "hosts"
+	BlockingBackendDNS   = "dns"
+	BlockingBackendProxy = "proxy"
+)
+
+// Blocker es un mecanismo de bloqueo de URLs. Apply reemplaza el conjunto
+// completo de reglas bloqueadas; Clear libera todo.
+type Blocker interface {
+	Name() string
+	Apply(rules []URLRule) error
+	Clear() error
+	Close() error
+}
+
+// hostsBlocker bloquea escribiendo la sección administrada del archivo hosts.
+// No admite comodines reales: los expande a los subdominios configurados.
+type hostsBlocker struct {
+	manager    *HostsManager
+	subdomains func() []string
+}
+
+// NewHostsBlocker crea el backend basado en el archivo hosts.
+func NewHostsBlocker(manager *HostsManager) Blocker {
+	return &hostsBlocker{
+		manager:    manager,
+		subdomains: func() []string { return subdomainsFor(GetCurrentConfig()) },
+	}
+}
+
+func (b *hostsBlocker) Name() string {
+	return BlockingBackendHosts
+}
+
+func (b *hostsBlocker) Apply(rules []URLRule) error {
+	return b.manager.Apply(ExpandURLRules(rules, b.subdomains()))
+}
+
+func (b *hostsBlocker) Clear() error {
+	return b.manager.Clear()
+}
+
+func (b *hostsBlocker) Close() error {
+	return nil
+}
+
+// blockerKey identifica la configuración de backend para saber si el
+// monitor debe recrear el Blocker.
+func blockerKey(cfg ConfigResponse) string {
+	return fmt.Sprintf("%s|%s|%s|%s", cfg.BlockingBackend, cfg.DNSListen, cfg.DNSUpstream, cfg.ProxyListen)
+}
+
+// NewBlocker crea el backend de bloqueo indicado en la configuración. Si no
+// se indica ninguno se usa el archivo hosts.
+func NewBlocker(cfg ConfigResponse) (Blocker, error) {
+	switch cfg.BlockingBackend {
+	case "", BlockingBackendHosts:
+		return NewHostsBlocker(defaultHostsManager), nil
+	case BlockingBackendDNS:
+		listen := cfg.DNSListen
+		if listen == "" {
+			listen = DefaultDNSListen
+		}
+		return NewDNSBlocker(listen, cfg.DNSUpstream)
+	case BlockingBackendProxy:
+		listen := cfg.ProxyListen
+		if listen == "" {
+			listen = DefaultProxyListen
+		}
+		return NewProxyBlocker(listen)
+	default:
+		return nil, fmt.Errorf("backend de bloqueo desconocido: %q", cfg.BlockingBackend)
+	}
+}
+
+// blockerHolder conserva el Blocker activo y lo recrea cuando cambia la
+// configuración de backend, liberando lo bloqueado por el anterior.
+type blockerHolder struct {
+	mu      sync.Mutex
+	key     string
+	blocker Blocker
+}
+
+func (h *blockerHolder) get(cfg ConfigResponse) Blocker {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := blockerKey(cfg)
+	if h.blocker != nil && h.key == key {
+		return h.blocker
+	}
+
+	blocker, err := NewBlocker(cfg)
+	if err != nil {
+		// Se mantiene hasta que cambie la configuración del backend.
+		recordError("Error creando el backend de bloqueo %q, se usa el archivo hosts: %v\n", cfg.BlockingBackend, err)
+		blocker = NewHostsBlocker(defaultHostsManager)
+	}
+	if dns, ok := blocker.(*DNSBlocker); ok {
+		// El agente no cambia el DNS de las interfaces: sin esa configuración
+		// externa el resolvedor no recibe consultas y no bloquea nada.
+		recordError("El backend DNS escucha en %s pero el agente no configura el DNS del sistema; el equipo debe usar esa dirección como servidor DNS (GPO o DHCP) para que el bloqueo tenga efecto.\n", dns.Addr())
+	}
+	if h.blocker != nil {
+		if err := h.blocker.Clear(); err != nil {
+			log.Printf("Error liberando el backend de bloqueo %s: %v\n", h.blocker.Name(), err)
+		}
+		if err := h.blocker.Close(); err != nil {
+			log.Printf("Error cerrando el backend de bloqueo %s: %v\n", h.blocker.Name(), err)
+		}
+	}
+	log.Printf("Backend de bloqueo activo: %s\n", blocker.Name())
+	h.key = key
+	h.blocker = blocker
+	return blocker
+}
+
+// close libera y cierra el Blocker activo.
+func (h *blockerHolder) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.blocker == nil {
+		return
+	}
+	if err := h.blocker.Clear(); err != nil {
+		log.Printf("Error liberando el backend de bloqueo %s: %v\n", h.blocker.Name(), err)
+	}
+	if err := h.blocker.Close(); err != nil {
+		log.Printf("Error cerrando el backend de bloqueo %s: %v\n", h.blocker.Name(), err)
+	}
+	h.blocker = nil
+	h.key = ""
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// callStateBinding es la función que Chrome expone en la página para que el
+// MutationObserver notifique a Go vía Runtime.bindingCalled.
+const callStateBinding = "__scrapeBlockerCallState"
+
+// observerScript instala (o reemplaza) un MutationObserver que reevalúa las
+// reglas ante cada ráfaga de mutaciones y notifica solo cuando el resultado
+// cambia. Retorna la observación inicial.
+const observerScript = `(function (rules, binding) {` + detectionLibrary + `
+	if (window.__scrapeBlockerObserver) { window.__scrapeBlockerObserver.disconnect(); }
+	var last = null;
+	function report() {
+		var matched = matchRules(rules).filter(function (m) { return m.count > 0; }).map(function (m) { return m.name; });
+		var observation = { inCall: matched.length > 0, rules: matched };
+		var key = JSON.stringify(observation);
+		if (key !== last) {
+			last = key;
+			if (typeof window[binding] === 'function') { window[binding](key); }
+		}
+		return observation;
+	}
+	var scheduled = false;
+	var observer = new MutationObserver(function () {
+		if (scheduled) { return; }
+		scheduled = true;
+		setTimeout(function () { scheduled = false; report(); }, 50);
+	});
+	observer.observe(document, { childList: true, subtree: true, attributes: true, characterData: true });
+	window.__scrapeBlockerObserver = observer;
+	return report();
+})(%s, %q)`
+
+// CallObservation es el estado reportado por el observador de la página.
+type CallObservation struct {
+	InCall bool     `json:"inCall"`
+	Rules  []string `json:"rules"`
+}
+
+// observationFromMatches arma la observación a partir de los conteos por
+// regla de QueryDetectionRules.
+func observationFromMatches(matches []DetectionMatch) CallObservation {
+	var observation CallObservation
+	for _, match := range matches {
+		if match.Error != "" {
+			log.Printf("Error evaluando la regla de detección %q: %s\n", match.Name, match.Error)
+		}
+		if match.Matched() {
+			observation.InCall = true
+			observation.Rules = append(observation.Rules, match.Name)
+		}
+	}
+	return observation
+}
+
+// observerState registra qué reglas tiene instaladas el observador de una sesión.
+type observerState struct {
+	rulesJSON string
+	scriptID  string
+}
+
+// InstallCallObserver instala el observador de llamadas en la pestaña
+// monitoreada, también para los documentos que se carguen después. Si ya
+// está instalado con las mismas reglas retorna nil sin hacer nada.
+func (s *chromeServiceImpl) InstallCallObserver(rules []domain.DetectionRule) (*CallObservation, error) {
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return nil, fmt.Errorf("error serializando las reglas de detección: %v", err)
+	}
+
+	sessionID, err := s.monitoredSession()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	state, installed := s.observers[sessionID]
+	s.mu.Unlock()
+	if installed && state.rulesJSON == string(rulesJSON) {
+		return nil, nil
+	}
+
+	if err := s.call(sessionID, "Runtime.addBinding", map[string]interface{}{"name": callStateBinding}, nil); err != nil {
+		return nil, err
+	}
+	if err := s.call(sessionID, "Page.enable", nil, nil); err != nil {
+		return nil, err
+	}
+	if installed && state.scriptID != "" {
+		params := map[string]interface{}{"identifier": state.scriptID}
+		if err := s.call(sessionID, "Page.removeScriptToEvaluateOnNewDocument", params, nil); err != nil {
+			log.Printf("Error retirando el observador anterior: %v\n", err)
+		}
+	}
+
+	script := fmt.Sprintf(observerScript, rulesJSON, callStateBinding)
+	var added struct {
+		Identifier string `json:"identifier"`
+	}
+	if err := s.call(sessionID, "Page.addScriptToEvaluateOnNewDocument", map[string]interface{}{"source": script}, &added); err != nil {
+		return nil, err
+	}
+
+	if err := s.evaluate(sessionID, script, nil); err != nil {
+		return nil, fmt.Errorf("error instalando el observador de llamadas: %v", err)
+	}
+
+	// El estado inicial se consulta con los mismos conteos por regla que
+	// QueryDetectionRules, que además informan las reglas que fallan.
+	matches, err := s.QueryDetectionRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	observation := observationFromMatches(matches)
+
+	s.mu.Lock()
+	s.observers[sessionID] = observerState{rulesJSON: string(rulesJSON), scriptID: added.Identifier}
+	s.mu.Unlock()
+
+	log.Println("Observador de llamadas instalado en la pestaña monitoreada")
+	return &observation, nil
+}
+
+// CallEvent notifica el inicio o el fin de una interacción.
+type CallEvent struct {
+	InCall bool
+	Rules  []string
+	At     time.Time
+}
+
+// CallStateDetector mantiene el estado de llamada reportado por el
+// MutationObserver inyectado en la página de Genesys y emite un CallEvent
+// en cuanto cambia.
+type CallStateDetector struct {
+	chrome ChromeService
+	events chan CallEvent
+
+	mu     sync.Mutex
+	inCall bool
+}
+
+// NewCallStateDetector crea el detector y comienza a escuchar los eventos de Chrome.
+func NewCallStateDetector(chrome ChromeService) *CallStateDetector {
+	d := &CallStateDetector{
+		chrome: chrome,
+		events: make(chan CallEvent, 16),
+	}
+	go d.listen(chrome.Subscribe())
+	return d
+}
+
+// Events entrega los cambios de estado de llamada.
+func (d *CallStateDetector) Events() <-chan CallEvent {
+	return d.events
+}
+
+// InCall retorna el último estado de llamada conocido.
+func (d *CallStateDetector) InCall() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inCall
+}
+
+// Ensure verifica que el observador esté instalado con las reglas vigentes.
+// Ante un error el estado pasa a "sin llamada", igual que el monitor por sondeo.
+func (d *CallStateDetector) Ensure(rules []domain.DetectionRule) error {
+	observation, err := d.chrome.InstallCallObserver(rules)
+	if err != nil {
+		d.update(CallObservation{})
+		return err
+	}
+	if observation != nil {
+		d.update(*observation)
+	}
+	return nil
+}
+
+func (d *CallStateDetector) listen(events <-chan CDPEvent) {
+	for event := range events {
+		if event.Method != "Runtime.bindingCalled" {
+			continue
+		}
+		var params struct {
+			Name    string `json:"name"`
+			Payload string `json:"payload"`
+		}
+		if err := json.Unmarshal(event.Params, &params); err != nil || params.Name != callStateBinding {
+			continue
+		}
+		var observation CallObservation
+		if err := json.Unmarshal([]byte(params.Payload), &observation); err != nil {
+			log.Printf("Notificación de llamada inválida: %v\n", err)
+			continue
+		}
+		d.update(observation)
+	}
+}
+
+func (d *CallStateDetector) update(observation CallObservation) {
+	d.mu.Lock()
+	changed := d.inCall != observation.InCall
+	d.inCall = observation.InCall
+	d.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if observation.InCall {
+		log.Printf("Llamada iniciada (reglas: %v)\n", observation.Rules)
+	} else {
+		log.Println("Llamada finalizada")
+	}
+
+	select {
+	case d.events <- CallEvent{InCall: observation.InCall, Rules: observation.Rules, At: time.Now()}:
+	default:
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// CDPEvent es un evento enviado por Chrome a través del protocolo DevTools.
+// SessionID identifica la pestaña de origen cuando el evento no es del navegador.
+type CDPEvent struct {
+	Method    string          `json:"method"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+}
+
+type cdpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type cdpMessage struct {
+	ID        int64           `json:"id,omitempty"`
+	Method    string          `json:"method,omitempty"`
+	Params    json.RawMessage `json:"params,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	Error     *cdpError       `json:"error,omitempty"`
+}
+
+// cdpClient mantiene una única conexión a nivel de navegador. Las respuestas
+// se correlacionan por id y los eventos se entregan por un canal aparte, por
+// lo que un evento nunca se confunde con la respuesta de un comando.
+type cdpClient struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan cdpMessage
+
+	events chan CDPEvent
+	done   chan struct{}
+	err    error
+}
+
+// dialCDP se conecta al endpoint de navegador anunciado en /json/version.
+func dialCDP(debuggerURL string) (*cdpClient, error) {
+	// Un Chrome colgado no debe bloquear el ciclo del monitor.
+	client := http.Client{
+		Timeout: cdpCallTimeout,
+	}
+
+	resp, err := client.Get(debuggerURL + "/json/version")
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to Chrome DevTools: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var version struct {
+		WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return nil, fmt.Errorf("error decoding browser version: %v", err)
+	}
+	if version.WebSocketDebuggerURL == "" {
+		return nil, fmt.Errorf("Chrome no anunció un webSocketDebuggerUrl de navegador")
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(version.WebSocketDebuggerURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to WebSocket: %v", err)
+	}
+
+	c := &cdpClient{
+		conn:    conn,
+		pending: make(map[int64]chan cdpMessage),
+		events:  make(chan CDPEvent, 256),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+func (c *cdpClient) readLoop() {
+	defer close(c.events)
+	for {
+		var msg cdpMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			c.shutdown(fmt.Errorf("conexión con Chrome perdida: %v", err))
+			return
+		}
+
+		if msg.ID != 0 {
+			c.mu.Lock()
+			ch, ok := c.pending[msg.ID]
+			delete(c.pending, msg.ID)
+			c.mu.Unlock()
+			if ok {
+				ch <- msg
+			}
+			continue
+		}
+
+		select {
+		case c.events <- CDPEvent{Method: msg.Method, Params: msg.Params, SessionID: msg.SessionID}:
+		default:
+			log.Printf("Cola de eventos CDP llena, se descarta %s\n", msg.Method)
+		}
+	}
+}
+
+// shutdown marca el cliente como cerrado y libera a los comandos en espera.
+func (c *cdpClient) shutdown(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.err = err
+	close(c.done)
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+}
+
+// Call envía un comando y espera su respuesta. Si result no es nil, el
+// resultado se deserializa en él. sessionID vacío envía el comando al navegador.
+func (c *cdpClient) Call(ctx context.Context, sessionID, method string, params interface{}, result interface{}) error {
+	ch := make(chan cdpMessage, 1)
+
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return c.err
+	default:
+	}
+	c.nextID++
+	id := c.nextID
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	request := map[string]interface{}{
+		"id":     id,
+		"method": method,
+	}
+	if params != nil {
+		request["params"] = params
+	}
+	if sessionID != "" {
+		request["sessionId"] = sessionID
+	}
+
+	c.writeMu.Lock()
+	err := c.conn.WriteJSON(request)
+	c.writeMu.Unlock()
+	if err != nil {
+		c.forget(id)
+		return fmt.Errorf("error al enviar %s: %v", method, err)
+	}
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return fmt.Errorf("error al leer la respuesta de %s: %v", method, c.err)
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("error desde CDP en %s: %s", method, msg.Error.Message)
+		}
+		if result != nil && len(msg.Result) > 0 {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("error al decodificar la respuesta de %s: %v", method, err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		c.forget(id)
+		return fmt.Errorf("tiempo de espera agotado en %s: %v", method, ctx.Err())
+	}
+}
+
+func (c *cdpClient) forget(id int64) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// Events entrega los eventos recibidos. El canal se cierra al perder la conexión.
+func (c *cdpClient) Events() <-chan CDPEvent {
+	return c.events
+}
+
+// Done se cierra cuando la conexión deja de estar disponible.
+func (c *cdpClient) Done() <-chan struct{} {
+	return c.done
+}
+
+// Close cierra la conexión con el navegador.
+func (c *cdpClient) Close() error {
+	c.shutdown(fmt.Errorf("conexión con Chrome cerrada"))
+	return c.conn.Close()
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// DetectionMatch es el resultado de evaluar una regla de detección en el DOM.
+type DetectionMatch struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+	Error string `json:"error,omitempty"`
+}
+
+// Matched indica si la regla encontró al menos un nodo.
+func (m DetectionMatch) Matched() bool {
+	return m.Count > 0
+}
+
+// detectionLibrary define matchRules(rules), que evalúa las reglas en el
+// documento y en todos los iframes accesibles. El texto se busca solo en
+// nodos de texto visibles para el DOM (se excluyen <script> y <style>) para
+// evitar falsos positivos.
+const detectionLibrary = `
+	function documents(root) {
+		var result = [root];
+		var frames = root.querySelectorAll('iframe, frame');
+		for (var i = 0; i < frames.length; i++) {
+			try {
+				var doc = frames[i].contentDocument;
+				if (doc) { result = result.concat(documents(doc)); }
+			} catch (e) {}
+		}
+		return result;
+	}
+	function countText(doc, re) {
+		var count = 0;
+		var walker = doc.createTreeWalker(doc.body || doc, NodeFilter.SHOW_TEXT);
+		while (walker.nextNode()) {
+			var parent = walker.currentNode.parentNode;
+			if (parent && /^(SCRIPT|STYLE|NOSCRIPT)$/.test(parent.nodeName)) { continue; }
+			if (re.test(walker.currentNode.nodeValue)) { count++; }
+		}
+		return count;
+	}
+	function countRule(doc, rule) {
+		switch (rule.type) {
+		case 'css':
+			return doc.querySelectorAll(rule.value).length;
+		case 'xpath':
+			return doc.evaluate(rule.value, doc, null, XPathResult.ORDERED_NODE_SNAPSHOT_TYPE, null).snapshotLength;
+		case 'text':
+			return countText(doc, new RegExp(rule.value, rule.flags || ''));
+		case 'attribute':
+			var nodes = doc.querySelectorAll('[' + CSS.escape(rule.attribute) + ']');
+			var count = 0;
+			for (var i = 0; i < nodes.length; i++) {
+				if (nodes[i].getAttribute(rule.attribute).indexOf(rule.value) !== -1) { count++; }
+			}
+			return count;
+		}
+		throw new Error('tipo de regla desconocido: ' + rule.type);
+	}
+	function matchRules(rules) {
+		var docs = documents(document);
+		return rules.map(function (rule) {
+			var match = { name: rule.name, count: 0 };
+			for (var i = 0; i < docs.length; i++) {
+				try {
+					match.count += countRule(docs[i], rule);
+				} catch (e) {
+					match.error = String(e);
+				}
+			}
+			return match;
+		});
+	}
+`
+
+// detectionScript evalúa una sola vez las reglas recibidas como JSON.
+const detectionScript = `(function (rules) {` + detectionLibrary + `
+	return matchRules(rules);
+})(%s)`
+
+// QueryDetectionRules evalúa las reglas directamente en el DOM de la pestaña
+// monitoreada mediante Runtime.evaluate y retorna cuántos nodos encontró cada una.
+func (s *chromeServiceImpl) QueryDetectionRules(rules []domain.DetectionRule) ([]DetectionMatch, error) {
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return nil, fmt.Errorf("error serializando las reglas de detección: %v", err)
+	}
+
+	sessionID, err := s.monitoredSession()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []DetectionMatch
+	if err := s.evaluate(sessionID, fmt.Sprintf(detectionScript, rulesJSON), &matches); err != nil {
+		return nil, fmt.Errorf("error evaluando las reglas de detección: %v", err)
+	}
+	return matches, nil
+}
@@ -1,19 +1,29 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"log"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
 )
 
+const cdpCallTimeout = 10 * time.Second
+
 type ChromeService interface {
 	Connect() error
-	GetFullPageHTML() (string, error)
+	Connected() bool
+	QueryDetectionRules(rules []domain.DetectionRule) ([]DetectionMatch, error)
+	InstallCallObserver(rules []domain.DetectionRule) (*CallObservation, error)
+	Subscribe() <-chan CDPEvent
 	Close()
 	BlockURLsInHosts(urlsToBlock []string) error
+	RedirectBlockedTabs(urlsToBlock []string) error
 	NavigateBackToPreviousURLs() error
 }
 
@@ -22,128 +32,337 @@ type TabInfo struct {
 	URL string `json:"url"`
 }
 
+// targetInfo es la descripción de un target según el dominio Target de CDP.
+type targetInfo struct {
+	TargetID string `json:"targetId"`
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+}
+
+// chromeServiceImpl mantiene una conexión persistente con el navegador, se
+// adjunta a las pestañas en modo flatten y reenvía los eventos de targets y
+// del DOM al monitor.
 type chromeServiceImpl struct {
-	conn         *websocket.Conn
+	debuggerURL  string
 	urlToMonitor string
 	tabPrevURLs  map[string]string
+
+	connectMu   sync.Mutex
+	mu          sync.Mutex
+	client      *cdpClient
+	targets     map[string]targetInfo
+	sessions    map[string]string
+	domReady    map[string]bool
+	observers   map[string]observerState
+	subscribers []chan CDPEvent
 }
 
 func NewChromeService(urlToMonitor string) ChromeService {
 	return &chromeServiceImpl{
+		debuggerURL:  "http://localhost:9222",
 		urlToMonitor: urlToMonitor,
 		tabPrevURLs:  make(map[string]string),
 	}
 }
 
+// Connect asegura la conexión con el navegador y la sesión con la pestaña monitoreada.
 func (s *chromeServiceImpl) Connect() error {
-	url := "http://localhost:9222/json"
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("error connecting to Chrome DevTools: %v", err)
-	}
-	defer resp.Body.Close()
+	_, err := s.monitoredSession()
+	return err
+}
 
-	var targets []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
-		return fmt.Errorf("error decoding targets: %v", err)
+// Connected indica si hay una conexión activa con el puerto de depuración,
+// sin intentar establecerla.
+func (s *chromeServiceImpl) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return false
+	}
+	select {
+	case <-s.client.Done():
+		return false
+	default:
+		return true
 	}
+}
 
-	var wsURL string
-	for _, target := range targets {
-		if target["type"] == "page" && strings.Contains(target["url"].(string), s.urlToMonitor) {
-			wsURL = target["webSocketDebuggerUrl"].(string)
-			break
+func (s *chromeServiceImpl) Close() {
+	s.connectMu.Lock()
+	defer s.connectMu.Unlock()
+
+	s.mu.Lock()
+	client := s.client
+	s.client = nil
+	s.mu.Unlock()
+
+	if client != nil {
+		if err := client.Close(); err != nil {
+			fmt.Printf("Error al cerrar la conexión: %v\n", err)
+		} else {
+			fmt.Println("Conexión a Chrome cerrada")
 		}
 	}
+}
+
+// Subscribe retorna un canal propio con los eventos recibidos de Chrome. Si
+// el suscriptor no consume a tiempo, los eventos se descartan.
+func (s *chromeServiceImpl) Subscribe() <-chan CDPEvent {
+	ch := make(chan CDPEvent, 64)
+	s.mu.Lock()
+	s.subscribers = append(s.subscribers, ch)
+	s.mu.Unlock()
+	return ch
+}
 
-	if wsURL == "" {
-		return fmt.Errorf("no suitable tab found with URL containing: %s", s.urlToMonitor)
+// ensureClient retorna el cliente activo o establece una nueva conexión,
+// activando el descubrimiento y el auto-attach de targets.
+func (s *chromeServiceImpl) ensureClient() (*cdpClient, error) {
+	s.connectMu.Lock()
+	defer s.connectMu.Unlock()
+
+	s.mu.Lock()
+	if s.client != nil {
+		select {
+		case <-s.client.Done():
+			s.client = nil
+		default:
+			client := s.client
+			s.mu.Unlock()
+			return client, nil
+		}
 	}
+	s.mu.Unlock()
 
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	client, err := dialCDP(s.debuggerURL)
 	if err != nil {
-		return fmt.Errorf("error connecting to WebSocket: %v", err)
+		return nil, err
 	}
-	s.conn = conn
-	return nil
+
+	s.mu.Lock()
+	s.client = client
+	s.targets = make(map[string]targetInfo)
+	s.sessions = make(map[string]string)
+	s.domReady = make(map[string]bool)
+	s.observers = make(map[string]observerState)
+	s.mu.Unlock()
+
+	go s.pumpEvents(client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cdpCallTimeout)
+	defer cancel()
+
+	if err := client.Call(ctx, "", "Target.setDiscoverTargets", map[string]interface{}{"discover": true}, nil); err != nil {
+		client.Close()
+		return nil, err
+	}
+	autoAttach := map[string]interface{}{
+		"autoAttach":             true,
+		"waitForDebuggerOnStart": false,
+		"flatten":                true,
+	}
+	if err := client.Call(ctx, "", "Target.setAutoAttach", autoAttach, nil); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	var result struct {
+		TargetInfos []targetInfo `json:"targetInfos"`
+	}
+	if err := client.Call(ctx, "", "Target.getTargets", nil, &result); err != nil {
+		client.Close()
+		return nil, err
+	}
+	s.mu.Lock()
+	for _, info := range result.TargetInfos {
+		s.targets[info.TargetID] = info
+	}
+	s.mu.Unlock()
+
+	log.Println("Conexión persistente con Chrome establecida")
+	return client, nil
 }
 
-func (s *chromeServiceImpl) Close() {
-	if s.conn != nil {
-		err := s.conn.Close()
-		if err != nil {
-			fmt.Printf("Error al cerrar la conexión: %v\n", err)
-		} else {
-			fmt.Println("Conexión a Chrome cerrada")
+// pumpEvents mantiene el estado de targets y sesiones y reenvía los eventos al monitor.
+func (s *chromeServiceImpl) pumpEvents(client *cdpClient) {
+	for event := range client.Events() {
+		s.trackTargetEvent(client, event)
+
+		s.mu.Lock()
+		subscribers := s.subscribers
+		s.mu.Unlock()
+		for _, ch := range subscribers {
+			select {
+			case ch <- event:
+			default:
+			}
 		}
-		s.conn = nil
 	}
 }
 
-func (s *chromeServiceImpl) GetFullPageHTML() (string, error) {
-	err := s.Connect()
-	if err != nil {
-		return "", err
+func (s *chromeServiceImpl) trackTargetEvent(client *cdpClient, event CDPEvent) {
+	var params struct {
+		TargetInfo targetInfo `json:"targetInfo"`
+		TargetID   string     `json:"targetId"`
+		SessionID  string     `json:"sessionId"`
+	}
+	if len(event.Params) > 0 {
+		if err := json.Unmarshal(event.Params, &params); err != nil {
+			return
+		}
 	}
-	defer s.Close()
 
-	request := map[string]interface{}{
-		"id":     1,
-		"method": "DOM.getDocument",
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Los eventos de una conexión anterior ya no describen el estado actual.
+	if s.client != client {
+		return
 	}
 
-	if err := s.conn.WriteJSON(request); err != nil {
-		return "", fmt.Errorf("error sending command: %v", err)
+	switch event.Method {
+	case "Target.targetCreated", "Target.targetInfoChanged":
+		s.targets[params.TargetInfo.TargetID] = params.TargetInfo
+	case "Target.targetDestroyed":
+		delete(s.targets, params.TargetID)
+		delete(s.sessions, params.TargetID)
+	case "Target.attachedToTarget":
+		s.targets[params.TargetInfo.TargetID] = params.TargetInfo
+		s.sessions[params.TargetInfo.TargetID] = params.SessionID
+	case "Target.detachedFromTarget":
+		for targetID, sessionID := range s.sessions {
+			if sessionID == params.SessionID {
+				delete(s.sessions, targetID)
+			}
+		}
+		delete(s.domReady, params.SessionID)
+		delete(s.observers, params.SessionID)
+	case "DOM.documentUpdated":
+		delete(s.domReady, event.SessionID)
 	}
+}
 
-	var response map[string]interface{}
-	if err := s.conn.ReadJSON(&response); err != nil {
-		return "", fmt.Errorf("error reading response: %v", err)
+// call envía un comando con el tiempo de espera por defecto.
+func (s *chromeServiceImpl) call(sessionID, method string, params interface{}, result interface{}) error {
+	client, err := s.ensureClient()
+	if err != nil {
+		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), cdpCallTimeout)
+	defer cancel()
+	return client.Call(ctx, sessionID, method, params, result)
+}
 
-	result, ok := response["result"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("unexpected response format: %v", response)
+// pages retorna las pestañas abiertas conocidas.
+func (s *chromeServiceImpl) pages() ([]targetInfo, error) {
+	if _, err := s.ensureClient(); err != nil {
+		return nil, err
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	root, ok := result["root"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("unexpected root format: %v", result)
+	var pages []targetInfo
+	for _, info := range s.targets {
+		if info.Type == "page" {
+			pages = append(pages, info)
+		}
 	}
+	return pages, nil
+}
 
-	rootNodeID, ok := root["nodeId"].(float64)
-	if !ok {
-		return "", fmt.Errorf("unexpected nodeId format: %v", root)
+// sessionFor retorna la sesión flatten de la pestaña, adjuntándose si hace falta.
+func (s *chromeServiceImpl) sessionFor(targetID string) (string, error) {
+	s.mu.Lock()
+	sessionID, ok := s.sessions[targetID]
+	s.mu.Unlock()
+	if ok {
+		return sessionID, nil
 	}
 
-	request = map[string]interface{}{
-		"id":     2,
-		"method": "DOM.getOuterHTML",
-		"params": map[string]interface{}{
-			"nodeId": rootNodeID,
-		},
+	var result struct {
+		SessionID string `json:"sessionId"`
+	}
+	params := map[string]interface{}{"targetId": targetID, "flatten": true}
+	if err := s.call("", "Target.attachToTarget", params, &result); err != nil {
+		return "", err
 	}
 
-	if err := s.conn.WriteJSON(request); err != nil {
-		return "", fmt.Errorf("error sending command: %v", err)
+	s.mu.Lock()
+	s.sessions[targetID] = result.SessionID
+	s.mu.Unlock()
+	return result.SessionID, nil
+}
+
+// monitoredSession retorna la sesión de la pestaña monitoreada y activa en
+// ella el dominio DOM para recibir los eventos de mutación.
+func (s *chromeServiceImpl) monitoredSession() (string, error) {
+	pages, err := s.pages()
+	if err != nil {
+		return "", err
 	}
 
-	if err := s.conn.ReadJSON(&response); err != nil {
-		return "", fmt.Errorf("error reading response: %v", err)
+	var targetID string
+	for _, page := range pages {
+		if strings.Contains(page.URL, s.urlToMonitor) {
+			targetID = page.TargetID
+			break
+		}
+	}
+	if targetID == "" {
+		return "", fmt.Errorf("no suitable tab found with URL containing: %s", s.urlToMonitor)
 	}
 
-	result, ok = response["result"].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("unexpected response format: %v", response)
+	sessionID, err := s.sessionFor(targetID)
+	if err != nil {
+		return "", err
 	}
 
-	htmlContent, ok := result["outerHTML"].(string)
-	if !ok {
-		return "", fmt.Errorf("unexpected outerHTML format: %v", result)
+	s.mu.Lock()
+	ready := s.domReady[sessionID]
+	s.mu.Unlock()
+	if !ready {
+		if err := s.call(sessionID, "DOM.enable", nil, nil); err != nil {
+			return "", err
+		}
+		// Solicitar el árbol completo hace que Chrome notifique las mutaciones de cualquier nodo.
+		params := map[string]interface{}{"depth": -1, "pierce": true}
+		if err := s.call(sessionID, "DOM.getDocument", params, nil); err != nil {
+			return "", err
+		}
+		s.mu.Lock()
+		s.domReady[sessionID] = true
+		s.mu.Unlock()
 	}
 
-	fmt.Println("Contenido HTML de la página obtenido exitosamente")
-	return htmlContent, nil
+	return sessionID, nil
+}
+
+// evaluate ejecuta una expresión en la sesión indicada y deserializa su valor en value.
+func (s *chromeServiceImpl) evaluate(sessionID, expression string, value interface{}) error {
+	params := map[string]interface{}{
+		"expression":    expression,
+		"returnByValue": true,
+	}
+	var result struct {
+		Result struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	if err := s.call(sessionID, "Runtime.evaluate", params, &result); err != nil {
+		return err
+	}
+	if result.ExceptionDetails != nil {
+		return fmt.Errorf("excepción en Runtime.evaluate: %s", result.ExceptionDetails.Text)
+	}
+	if value != nil && len(result.Result.Value) > 0 {
+		if err := json.Unmarshal(result.Result.Value, value); err != nil {
+			return fmt.Errorf("error al decodificar el resultado de Runtime.evaluate: %v", err)
+		}
+	}
+	return nil
 }
 
 func (s *chromeServiceImpl) BlockURLsInHosts(urlsToBlock []string) error {
@@ -153,47 +372,37 @@ func (s *chromeServiceImpl) BlockURLsInHosts(urlsToBlock []string) error {
 		return err
 	}
 
+	return s.RedirectBlockedTabs(urlsToBlock)
+}
+
+// RedirectBlockedTabs envía a about:blank las pestañas cuyo host está
+// bloqueado, recordando su URL para restaurarla al desbloquear.
+func (s *chromeServiceImpl) RedirectBlockedTabs(urlsToBlock []string) error {
 	// Obtener todas las pestañas abiertas
-	url := "http://localhost:9222/json"
-	resp, err := http.Get(url)
+	pages, err := s.pages()
 	if err != nil {
 		return fmt.Errorf("error al conectar con Chrome DevTools: %v", err)
 	}
-	defer resp.Body.Close()
 
-	var targets []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
-		return fmt.Errorf("error al decodificar los targets: %v", err)
-	}
+	rules, _ := ParseURLRules(urlsToBlock)
 
 	// Recorrer todas las pestañas y aplicar la acción a las que coincidan
-	for _, target := range targets {
-		pageURL, ok := target["url"].(string)
-		if !ok {
+	for _, page := range pages {
+		pageURL, err := url.Parse(page.URL)
+		if err != nil || pageURL.Host == "" {
 			continue
 		}
-		tabID, ok := target["id"].(string)
-		if !ok {
-			continue
-		}
-
-		// Verificar si la URL de la pestaña coincide con alguna de las URLs a bloquear
-		for _, blockedURL := range urlsToBlock {
-			if strings.Contains(pageURL, blockedURL) {
-				wsURL, ok := target["webSocketDebuggerUrl"].(string)
-				if !ok {
-					fmt.Printf("No se pudo obtener wsURL para la pestaña con URL %s\n", pageURL)
-					continue
-				}
 
+		// Verificar si la URL de la pestaña está cubierta por alguna de las URLs a bloquear
+		for _, rule := range rules {
+			if rule.MatchesURL(pageURL) {
 				// Almacenar la URL anterior
-				s.tabPrevURLs[tabID] = pageURL
+				s.tabPrevURLs[page.TargetID] = page.URL
 
-				// Conectar a la pestaña y aplicar la acción
-				if err := s.applyActionToTab(wsURL); err != nil {
-					fmt.Printf("Error al aplicar la acción a la pestaña con URL %s: %v\n", pageURL, err)
+				if err := s.navigateTab(page.TargetID, "about:blank"); err != nil {
+					fmt.Printf("Error al aplicar la acción a la pestaña con URL %s: %v\n", page.URL, err)
 				} else {
-					fmt.Printf("Acción aplicada a la pestaña con URL %s\n", pageURL)
+					fmt.Printf("Acción aplicada a la pestaña con URL %s\n", page.URL)
 				}
 
 				// No necesitamos seguir verificando otras URLs para esta pestaña
@@ -207,158 +416,46 @@ func (s *chromeServiceImpl) BlockURLsInHosts(urlsToBlock []string) error {
 
 func (s *chromeServiceImpl) NavigateBackToPreviousURLs() error {
 	// Obtener todas las pestañas abiertas
-	url := "http://localhost:9222/json"
-	resp, err := http.Get(url)
+	pages, err := s.pages()
 	if err != nil {
 		return fmt.Errorf("error al conectar con Chrome DevTools: %v", err)
 	}
-	defer resp.Body.Close()
-
-	var targets []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&targets); err != nil {
-		return fmt.Errorf("error al decodificar los targets: %v", err)
-	}
 
 	// Recorrer todas las pestañas y navegar de regreso a las URLs anteriores
-	for _, target := range targets {
-		tabID, ok := target["id"].(string)
-		if !ok {
-			continue
-		}
-
-		prevURL, exists := s.tabPrevURLs[tabID]
+	for _, page := range pages {
+		prevURL, exists := s.tabPrevURLs[page.TargetID]
 		if !exists {
 			continue
 		}
 
-		wsURL, ok := target["webSocketDebuggerUrl"].(string)
-		if !ok {
-			fmt.Printf("No se pudo obtener wsURL para la pestaña con ID %s\n", tabID)
-			continue
-		}
-
-		// Conectar a la pestaña y navegar de regreso
-		if err := s.navigateToURLInTab(wsURL, prevURL); err != nil {
-			fmt.Printf("Error al navegar de regreso en la pestaña con ID %s: %v\n", tabID, err)
+		if err := s.navigateTab(page.TargetID, prevURL); err != nil {
+			fmt.Printf("Error al navegar de regreso en la pestaña con ID %s: %v\n", page.TargetID, err)
 		} else {
-			fmt.Printf("Navegado de regreso a %s en la pestaña con ID %s\n", prevURL, tabID)
+			fmt.Printf("Navegado de regreso a %s en la pestaña con ID %s\n", prevURL, page.TargetID)
 		}
 
 		// Remover el tabID del mapa, ya que hemos navegado de regreso
-		delete(s.tabPrevURLs, tabID)
+		delete(s.tabPrevURLs, page.TargetID)
 	}
 
 	return nil
 }
 
-func (s *chromeServiceImpl) navigateToURLInTab(wsURL string, urlToNavigate string) error {
-	// Conectar al WebSocket de la pestaña
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+// navigateTab navega la pestaña indicada a la URL usando su sesión flatten.
+func (s *chromeServiceImpl) navigateTab(targetID, urlToNavigate string) error {
+	sessionID, err := s.sessionFor(targetID)
 	if err != nil {
-		return fmt.Errorf("error al conectar al WebSocket: %v", err)
-	}
-	defer conn.Close()
-
-	// Contador de IDs para las solicitudes
-	var requestID int = 1
-
-	// Habilitar el dominio Page
-	request := map[string]interface{}{
-		"id":     requestID,
-		"method": "Page.enable",
-	}
-	requestID++
-
-	if err := conn.WriteJSON(request); err != nil {
-		return fmt.Errorf("error al enviar Page.enable: %v", err)
-	}
-
-	var response map[string]interface{}
-	if err := conn.ReadJSON(&response); err != nil {
-		return fmt.Errorf("error al leer la respuesta de Page.enable: %v", err)
-	}
-
-	// Verificar si hay errores en la respuesta
-	if errObj, exists := response["error"]; exists {
-		return fmt.Errorf("error desde CDP en Page.enable: %v", errObj)
-	}
-
-	// Navegar a la URL anterior
-	request = map[string]interface{}{
-		"id":     requestID,
-		"method": "Page.navigate",
-		"params": map[string]interface{}{
-			"url": urlToNavigate,
-		},
-	}
-	requestID++
-
-	if err := conn.WriteJSON(request); err != nil {
-		return fmt.Errorf("error al enviar Page.navigate: %v", err)
-	}
-
-	if err := conn.ReadJSON(&response); err != nil {
-		return fmt.Errorf("error al leer la respuesta de Page.navigate: %v", err)
-	}
-
-	// Verificar si hay errores en la respuesta
-	if errObj, exists := response["error"]; exists {
-		return fmt.Errorf("error desde CDP en Page.navigate: %v", errObj)
-	}
-
-	fmt.Println("Página navegada exitosamente en la pestaña")
-	return nil
-}
-
-func (s *chromeServiceImpl) applyActionToTab(wsURL string) error {
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
-	if err != nil {
-		return fmt.Errorf("error al conectar al WebSocket: %v", err)
-	}
-	defer conn.Close()
-
-	var requestID int = 1
-
-	request := map[string]interface{}{
-		"id":     requestID,
-		"method": "Page.enable",
-	}
-	requestID++
-
-	if err := conn.WriteJSON(request); err != nil {
-		return fmt.Errorf("error al enviar Page.enable: %v", err)
-	}
-
-	var response map[string]interface{}
-	if err := conn.ReadJSON(&response); err != nil {
-		return fmt.Errorf("error al leer la respuesta de Page.enable: %v", err)
-	}
-
-	if errObj, exists := response["error"]; exists {
-		return fmt.Errorf("error desde CDP en Page.enable: %v", errObj)
-	}
-
-	request = map[string]interface{}{
-		"id":     requestID,
-		"method": "Page.navigate",
-		"params": map[string]interface{}{
-			"url": "about:blank",
-		},
+		return err
 	}
-	requestID++
 
-	if err := conn.WriteJSON(request); err != nil {
-		return fmt.Errorf("error al enviar Page.navigate: %v", err)
+	var result struct {
+		ErrorText string `json:"errorText"`
 	}
-
-	if err := conn.ReadJSON(&response); err != nil {
-		return fmt.Errorf("error al leer la respuesta de Page.navigate: %v", err)
+	if err := s.call(sessionID, "Page.navigate", map[string]interface{}{"url": urlToNavigate}, &result); err != nil {
+		return err
 	}
-
-	if errObj, exists := response["error"]; exists {
-		return fmt.Errorf("error desde CDP en Page.navigate: %v", errObj)
+	if result.ErrorText != "" {
+		return fmt.Errorf("error desde CDP en Page.navigate: %s", result.ErrorText)
 	}
-
-	fmt.Println("Página redirigida exitosamente en la pestaña")
 	return nil
 }
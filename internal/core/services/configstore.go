@@ -5,15 +5,26 @@ import (
 )
 
 var (
-	currentConfig ConfigResponse
-	mutex         sync.Mutex
+	currentConfig   ConfigResponse
+	mutex           sync.Mutex
+	configListeners = make(map[int]func(ConfigResponse))
+	nextListenerID  int
 )
 
-// SetCurrentConfig actualiza la configuración actual de forma segura.
+// SetCurrentConfig actualiza la configuración actual de forma segura y
+// notifica a los suscriptores registrados con OnConfigChange.
 func SetCurrentConfig(cfg ConfigResponse) {
 	mutex.Lock()
-	defer mutex.Unlock()
 	currentConfig = cfg
+	listeners := make([]func(ConfigResponse), 0, len(configListeners))
+	for _, listener := range configListeners {
+		listeners = append(listeners, listener)
+	}
+	mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener(cfg)
+	}
 }
 
 // GetCurrentConfig retorna la configuración actual de forma segura.
@@ -22,3 +33,18 @@ func GetCurrentConfig() ConfigResponse {
 	defer mutex.Unlock()
 	return currentConfig
 }
+
+// OnConfigChange registra una función que se invoca cada vez que se
+// actualiza la configuración. Retorna la función que cancela el registro.
+func OnConfigChange(listener func(ConfigResponse)) func() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	id := nextListenerID
+	nextListenerID++
+	configListeners[id] = listener
+	return func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		delete(configListeners, id)
+	}
+}
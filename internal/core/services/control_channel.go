@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"github.com/gorilla/websocket"
+)
+
+// ConnectionState es el estado del canal de control con el servidor.
+type ConnectionState string
+
+const (
+	// ConnectionConnected indica que la conexión está abierta y responde a los pings.
+	ConnectionConnected ConnectionState = "connected"
+	// ConnectionReconnecting indica que se perdió la conexión y se está reintentando.
+	ConnectionReconnecting ConnectionState = "reconnecting"
+	// ConnectionOffline indica que el servidor no responde desde hace tiempo
+	// (varios reintentos seguidos fallaron) o que el canal se detuvo.
+	ConnectionOffline ConnectionState = "offline"
+)
+
+const (
+	// pingInterval es cada cuánto se envía un ping al servidor.
+	pingInterval = 15 * time.Second
+	// pongWait es el plazo para recibir un pong o un mensaje antes de dar la
+	// conexión por perdida.
+	pongWait = 2 * pingInterval
+	// writeWait limita el tiempo de cada escritura en la conexión.
+	writeWait = 10 * time.Second
+	// reconnectBaseDelay y reconnectMaxDelay acotan la espera exponencial entre reintentos.
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+	// offlineAfterAttempts es la cantidad de reintentos fallidos seguidos
+	// (alrededor de medio minuto) tras la cual el canal se informa sin conexión.
+	offlineAfterAttempts = 5
+)
+
+var (
+	connectionState     = ConnectionOffline
+	connectionMu        sync.Mutex
+	connectionListeners = make(map[int]func(ConnectionState))
+	nextConnectionID    int
+)
+
+// GetConnectionState retorna el estado actual del canal de control.
+func GetConnectionState() ConnectionState {
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+	return connectionState
+}
+
+// OnConnectionStateChange registra una función que se invoca cada vez que
+// cambia el estado del canal de control. Retorna la función que cancela el
+// registro.
+func OnConnectionStateChange(listener func(ConnectionState)) func() {
+	connectionMu.Lock()
+	defer connectionMu.Unlock()
+	id := nextConnectionID
+	nextConnectionID++
+	connectionListeners[id] = listener
+	return func() {
+		connectionMu.Lock()
+		defer connectionMu.Unlock()
+		delete(connectionListeners, id)
+	}
+}
+
+// setConnectionState actualiza el estado y notifica a los suscriptores solo
+// si cambió.
+func setConnectionState(state ConnectionState) {
+	connectionMu.Lock()
+	if connectionState == state {
+		connectionMu.Unlock()
+		return
+	}
+	connectionState = state
+	listeners := make([]func(ConnectionState), 0, len(connectionListeners))
+	for _, listener := range connectionListeners {
+		listeners = append(listeners, listener)
+	}
+	connectionMu.Unlock()
+
+	log.Printf("Canal de control: %s\n", state)
+	updateAgentStatus(func(status *AgentStatus) {
+		status.Connection = state
+	})
+	for _, listener := range listeners {
+		listener(state)
+	}
+}
+
+// reconnectDelay retorna la espera antes del reintento indicado (desde 0):
+// crece al doble en cada intento hasta reconnectMaxDelay y se elige al azar
+// entre la mitad y el total para que los agentes no reconecten a la vez
+// tras un reinicio del servidor.
+func reconnectDelay(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 16 {
+		if exp := reconnectBaseDelay << attempt; exp < reconnectMaxDelay {
+			delay = exp
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// ConnectAndKeepOpen mantiene el canal de control con el servidor hasta que
+// se cancele ctx. Si la conexión se cierra, falla al conectar o deja de
+// responder a los pings, reintenta con espera exponencial; después de cada
+// reconexión vuelve a obtener la configuración para no perder los cambios
+// hechos mientras estuvo desconectado. Al cancelarse ctx se envía el cierre
+// normal.
+//
+// Los mensajes recibidos se procesan en una única goroutine, en orden de
+// llegada y a través de las reconexiones; el bucle de lectura solo los
+// decodifica y encola.
+func ConnectAndKeepOpen(ctx context.Context, wsURL string, user *domain.User) error {
+	defer setConnectionState(ConnectionOffline)
+
+	queue := make(chan Envelope, messageQueueSize)
+	defer close(queue)
+	go processMessages(queue, user)
+
+	attempt := 0
+	connected := false
+	for ctx.Err() == nil {
+		started := time.Now()
+		opened, err := serveConnection(ctx, wsURL, user, connected, queue)
+		if ctx.Err() != nil {
+			break
+		}
+		connected = connected || opened
+		// Solo una conexión que se sostuvo reinicia la espera; si el servidor
+		// acepta y corta de inmediato se sigue espaciando los reintentos.
+		if opened && time.Since(started) >= pingInterval {
+			attempt = 0
+		}
+		if err != nil {
+			log.Printf("Error en la conexión con el WebSocket: %v\n", err)
+		}
+
+		delay := reconnectDelay(attempt)
+		if attempt >= offlineAfterAttempts {
+			setConnectionState(ConnectionOffline)
+		} else {
+			setConnectionState(ConnectionReconnecting)
+		}
+		log.Printf("Reintentando la conexión con el WebSocket en %v\n", delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C:
+		}
+		attempt++
+	}
+	return nil
+}
+
+// serveConnection abre una conexión y encola sus mensajes en queue hasta que
+// se cierre. opened indica si se llegó a establecer la conexión.
+func serveConnection(ctx context.Context, wsURL string, user *domain.User, reconnect bool, queue chan<- Envelope) (opened bool, err error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("error al conectar con el WebSocket: %w", err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	closed := make(chan struct{})
+	defer close(closed)
+	go keepAlive(ctx, conn, closed)
+
+	wsMutex.Lock()
+	wsConn = conn
+	wsMutex.Unlock()
+	defer func() {
+		wsMutex.Lock()
+		wsConn = nil
+		wsMutex.Unlock()
+	}()
+
+	log.Println("Conexión establecida con el WebSocket")
+	setConnectionState(ConnectionConnected)
+	if err := sendHello(user); err != nil {
+		log.Printf("Error enviando el saludo al servidor: %v\n", err)
+	}
+	if reconnect {
+		enqueueEnvelope(queue, Envelope{Type: MessageRefresh, Version: ProtocolVersion, Timestamp: time.Now()})
+	} else {
+		ReportRejectedURLs(GetCurrentConfig(), user.Client)
+		ReportProcessSelectorWarnings(GetCurrentConfig(), user.Client)
+		ReportRejectedDetectionRules(GetCurrentConfig(), user.Client)
+	}
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Println("Conexión cerrada")
+				return true, nil
+			}
+			return true, fmt.Errorf("error al leer mensaje del servidor: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		log.Printf("Mensaje recibido del servidor: %s\n", message)
+
+		envelope, err := decodeEnvelope(message)
+		if err != nil {
+			log.Printf("Error procesando el mensaje del WebSocket: %v\n", err)
+			continue
+		}
+		enqueueEnvelope(queue, envelope)
+	}
+}
+
+// keepAlive envía pings periódicos hasta que se cierre la conexión. Al
+// cancelarse ctx envía el cierre normal y cierra la conexión para que la
+// lectura termine.
+func keepAlive(ctx context.Context, conn *websocket.Conn, closed <-chan struct{}) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			wsMutex.Lock()
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, "agent stopped"),
+				time.Now().Add(time.Second))
+			wsMutex.Unlock()
+			conn.Close()
+			return
+		case <-ticker.C:
+			wsMutex.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait))
+			wsMutex.Unlock()
+			if err != nil {
+				log.Printf("Error enviando el ping al servidor: %v\n", err)
+				conn.Close()
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
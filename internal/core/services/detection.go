@@ -0,0 +1,135 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// DefaultDetectionRules son las reglas incluidas en el ejecutable, usadas
+// cuando el servidor no entrega reglas válidas.
+func DefaultDetectionRules() []domain.DetectionRule {
+	return []domain.DetectionRule{
+		{Name: "llamada", Type: domain.DetectionCSS, Value: ".participant.call-participant.text-center.ember-view"},
+		{Name: "sms", Type: domain.DetectionCSS, Value: ".sms-textarea.message-input.form-control"},
+		{Name: "email", Type: domain.DetectionCSS, Value: ".interaction-icon.roster-email.ember-view"},
+	}
+}
+
+// ValidateDetectionRule verifica que la regla esté bien formada.
+func ValidateDetectionRule(rule domain.DetectionRule) error {
+	if rule.Value == "" {
+		return fmt.Errorf("la regla %q no tiene valor", rule.Name)
+	}
+	switch rule.Type {
+	case domain.DetectionCSS, domain.DetectionXPath:
+	case domain.DetectionText:
+		if err := validateTextPattern(rule.Value, rule.Flags); err != nil {
+			return fmt.Errorf("la regla %q tiene una expresión regular inválida: %v", rule.Name, err)
+		}
+	case domain.DetectionAttribute:
+		if rule.Attribute == "" {
+			return fmt.Errorf("la regla %q no indica el atributo", rule.Name)
+		}
+	default:
+		return fmt.Errorf("la regla %q tiene un tipo desconocido: %q", rule.Name, rule.Type)
+	}
+	if rule.Flags != "" && rule.Type != domain.DetectionText {
+		return fmt.Errorf("la regla %q indica opciones de expresión regular sin ser de texto", rule.Name)
+	}
+	return nil
+}
+
+// posixClass reconoce las clases [:alpha:] de Go, que JavaScript interpreta
+// como un conjunto de caracteres sueltos.
+var posixClass = regexp.MustCompile(`\[:\^?[a-z]+:\]`)
+
+// validateTextPattern verifica que la expresión regular sea válida tanto en
+// Go como en RegExp de JavaScript, donde se evalúa. Se rechaza la sintaxis
+// que solo existe en Go: las opciones en línea como (?i) se indican en Flags.
+func validateTextPattern(pattern, flags string) error {
+	for i, flag := range flags {
+		if !strings.ContainsRune("ims", flag) || strings.ContainsRune(flags[:i], flag) {
+			return fmt.Errorf("opciones %q no soportadas; se aceptan i, m y s", flags)
+		}
+	}
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\':
+			if i+1 < len(pattern) && strings.IndexByte("AzQECpP", pattern[i+1]) >= 0 {
+				return fmt.Errorf("\\%c no existe en JavaScript", pattern[i+1])
+			}
+			i++
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '(' && !inClass && strings.HasPrefix(pattern[i+1:], "?"):
+			if !jsGroupPrefix(pattern[i+1:]) {
+				return fmt.Errorf("el grupo %q no es común a Go y JavaScript; las opciones se indican en flags", pattern[i:min(len(pattern), i+4)])
+			}
+		}
+	}
+	if class := posixClass.FindString(pattern); class != "" {
+		return fmt.Errorf("la clase %s no existe en JavaScript", class)
+	}
+	goPattern := pattern
+	if flags != "" {
+		goPattern = "(?" + flags + ")" + pattern
+	}
+	if _, err := regexp.Compile(goPattern); err != nil {
+		return err
+	}
+	return nil
+}
+
+// jsGroupPrefix indica si el grupo que empieza en "?" es de los que ambos
+// motores entienden: sin captura (?:...) o con nombre (?<nombre>...).
+func jsGroupPrefix(group string) bool {
+	if strings.HasPrefix(group, "?:") {
+		return true
+	}
+	return strings.HasPrefix(group, "?<") && !strings.HasPrefix(group, "?<=") && !strings.HasPrefix(group, "?<!")
+}
+
+// detectionRulesFor retorna las reglas válidas entregadas por el servidor o,
+// si no hay ninguna, las reglas por defecto. Las descartadas se informan una
+// vez por configuración con ReportRejectedDetectionRules.
+func detectionRulesFor(cfg ConfigResponse) []domain.DetectionRule {
+	var rules []domain.DetectionRule
+	for _, rule := range cfg.DetectionRules {
+		if ValidateDetectionRule(rule) == nil {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return DefaultDetectionRules()
+	}
+	return rules
+}
+
+// ReportRejectedDetectionRules informa al servidor las reglas de detección
+// que se descartaron por no ser válidas.
+func ReportRejectedDetectionRules(cfg ConfigResponse, client string) {
+	var rejected []string
+	for _, rule := range cfg.DetectionRules {
+		if err := ValidateDetectionRule(rule); err != nil {
+			log.Printf("Regla de detección descartada: %v\n", err)
+			rejected = append(rejected, err.Error())
+		}
+	}
+	if len(rejected) == 0 {
+		return
+	}
+	report := map[string]interface{}{
+		"client":   client,
+		"rejected": rejected,
+	}
+	if err := SendWebSocketMessage("detection_rules_rejected", report); err != nil {
+		log.Printf("Error reportando las reglas de detección descartadas: %v\n", err)
+	}
+}
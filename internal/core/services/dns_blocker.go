@@ -0,0 +1,247 @@
+package services
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultDNSListen es la dirección en la que escucha el resolvedor local si
+// la configuración no indica otra.
+const DefaultDNSListen = "127.0.0.1:53"
+
+const dnsUpstreamTimeout = 3 * time.Second
+
+// DNSBlocker es un resolvedor local que responde 0.0.0.0 (o NXDOMAIN) para
+// los nombres bloqueados, con soporte completo de comodines, y reenvía el
+// resto de consultas al resolvedor upstream. Escucha en UDP y TCP. El DNS
+// del sistema debe apuntar a su dirección desde fuera del agente.
+type DNSBlocker struct {
+	upstream string
+	udpConn  net.PacketConn
+	tcpLn    net.Listener
+
+	mu    sync.RWMutex
+	rules []URLRule
+}
+
+// NewDNSBlocker inicia el resolvedor en listenAddr reenviando a upstream.
+func NewDNSBlocker(listenAddr, upstream string) (*DNSBlocker, error) {
+	if upstream == "" {
+		return nil, fmt.Errorf("el backend DNS requiere un resolvedor upstream")
+	}
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		upstream = net.JoinHostPort(upstream, "53")
+	}
+
+	udpConn, err := net.ListenPacket("udp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error escuchando DNS/UDP en %s: %v", listenAddr, err)
+	}
+	tcpLn, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		udpConn.Close()
+		return nil, fmt.Errorf("error escuchando DNS/TCP en %s: %v", listenAddr, err)
+	}
+
+	b := &DNSBlocker{upstream: upstream, udpConn: udpConn, tcpLn: tcpLn}
+	go b.serveUDP()
+	go b.serveTCP()
+	log.Printf("Resolvedor DNS local escuchando en %s (upstream %s)\n", udpConn.LocalAddr(), upstream)
+	return b, nil
+}
+
+func (b *DNSBlocker) Name() string {
+	return BlockingBackendDNS
+}
+
+// Addr retorna la dirección en la que escucha el resolvedor.
+func (b *DNSBlocker) Addr() string {
+	return b.udpConn.LocalAddr().String()
+}
+
+func (b *DNSBlocker) Apply(rules []URLRule) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rules = append([]URLRule(nil), rules...)
+	return nil
+}
+
+func (b *DNSBlocker) Clear() error {
+	return b.Apply(nil)
+}
+
+func (b *DNSBlocker) Close() error {
+	udpErr := b.udpConn.Close()
+	tcpErr := b.tcpLn.Close()
+	if udpErr != nil {
+		return udpErr
+	}
+	return tcpErr
+}
+
+// blocked indica si el nombre consultado está cubierto por alguna regla.
+func (b *DNSBlocker) blocked(name string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return matchesAnyRule(b.rules, name)
+}
+
+func (b *DNSBlocker) serveUDP() {
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := b.udpConn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			response, err := b.resolve(query, "udp")
+			if err != nil {
+				log.Printf("Error resolviendo consulta DNS: %v\n", err)
+				return
+			}
+			b.udpConn.WriteTo(response, addr)
+		}()
+	}
+}
+
+func (b *DNSBlocker) serveTCP() {
+	for {
+		conn, err := b.tcpLn.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(10 * time.Second))
+			query, err := readTCPMessage(conn)
+			if err != nil {
+				return
+			}
+			response, err := b.resolve(query, "tcp")
+			if err != nil {
+				log.Printf("Error resolviendo consulta DNS: %v\n", err)
+				return
+			}
+			writeTCPMessage(conn, response)
+		}()
+	}
+}
+
+// resolve responde localmente las consultas bloqueadas y reenvía las demás.
+func (b *DNSBlocker) resolve(query []byte, network string) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("consulta DNS inválida: %v", err)
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, fmt.Errorf("consulta DNS sin pregunta: %v", err)
+	}
+
+	name := strings.TrimSuffix(question.Name.String(), ".")
+	if b.blocked(name) {
+		return sinkholeResponse(header, question)
+	}
+	return b.forward(query, network)
+}
+
+// sinkholeResponse responde 0.0.0.0 o :: para A/AAAA y NXDOMAIN para el resto.
+func sinkholeResponse(query dnsmessage.Header, question dnsmessage.Question) ([]byte, error) {
+	header := dnsmessage.Header{
+		ID:                 query.ID,
+		Response:           true,
+		OpCode:             query.OpCode,
+		Authoritative:      true,
+		RecursionDesired:   query.RecursionDesired,
+		RecursionAvailable: true,
+	}
+	resource := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60}
+
+	switch question.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		header.RCode = dnsmessage.RCodeSuccess
+	default:
+		header.RCode = dnsmessage.RCodeNameError
+	}
+
+	builder := dnsmessage.NewBuilder(nil, header)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	switch question.Type {
+	case dnsmessage.TypeA:
+		if err := builder.AResource(resource, dnsmessage.AResource{}); err != nil {
+			return nil, err
+		}
+	case dnsmessage.TypeAAAA:
+		if err := builder.AAAAResource(resource, dnsmessage.AAAAResource{}); err != nil {
+			return nil, err
+		}
+	}
+	return builder.Finish()
+}
+
+// forward reenvía la consulta sin modificar al upstream por el mismo protocolo.
+func (b *DNSBlocker) forward(query []byte, network string) ([]byte, error) {
+	conn, err := net.DialTimeout(network, b.upstream, dnsUpstreamTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("error conectando con el upstream %s: %v", b.upstream, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsUpstreamTimeout))
+
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// readTCPMessage lee un mensaje DNS precedido por su longitud (RFC 1035 4.2.2).
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// upstreamAddress es la IP con la que responde el upstream falso a toda consulta A.
+var upstreamAddress = [4]byte{192, 0, 2, 7}
+
+// startFakeUpstream inicia un resolvedor en un puerto alto de 127.0.0.1 que
+// responde upstreamAddress por UDP y TCP.
+func startFakeUpstream(t *testing.T) string {
+	t.Helper()
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error iniciando el upstream UDP: %v", err)
+	}
+	tcpLn, err := net.Listen("tcp", udpConn.LocalAddr().String())
+	if err != nil {
+		udpConn.Close()
+		t.Fatalf("error iniciando el upstream TCP: %v", err)
+	}
+	t.Cleanup(func() {
+		udpConn.Close()
+		tcpLn.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := udpConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response, err := fakeUpstreamResponse(buf[:n]); err == nil {
+				udpConn.WriteTo(response, addr)
+			}
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tcpLn.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				if response, err := fakeUpstreamResponse(query); err == nil {
+					writeTCPMessage(conn, response)
+				}
+			}()
+		}
+	}()
+	return udpConn.LocalAddr().String()
+}
+
+func fakeUpstreamResponse(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, err
+	}
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, RecursionAvailable: true})
+	builder.StartQuestions()
+	builder.Question(question)
+	builder.StartAnswers()
+	builder.AResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 60},
+		dnsmessage.AResource{A: upstreamAddress})
+	return builder.Finish()
+}
+
+func newTestDNSBlocker(t *testing.T, entries ...string) *DNSBlocker {
+	t.Helper()
+	blocker, err := NewDNSBlocker("127.0.0.1:0", startFakeUpstream(t))
+	if err != nil {
+		t.Fatalf("error iniciando el resolvedor: %v", err)
+	}
+	t.Cleanup(func() { blocker.Close() })
+	rules, rejected := ParseURLRules(entries)
+	if len(rejected) > 0 {
+		t.Fatalf("entradas rechazadas: %+v", rejected)
+	}
+	if err := blocker.Apply(rules); err != nil {
+		t.Fatalf("error aplicando las reglas: %v", err)
+	}
+	return blocker
+}
+
+// queryDNS consulta name al resolvedor por la red indicada y retorna el
+// código de respuesta y las direcciones A recibidas.
+func queryDNS(t *testing.T, network, addr, name string, qtype dnsmessage.Type) (dnsmessage.RCode, [][4]byte) {
+	t.Helper()
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 0x2a, RecursionDesired: true})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name + "."), Type: qtype, Class: dnsmessage.ClassINET})
+	query, err := builder.Finish()
+	if err != nil {
+		t.Fatalf("error construyendo la consulta: %v", err)
+	}
+
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		t.Fatalf("error conectando con el resolvedor: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	var response []byte
+	if network == "tcp" {
+		if err := writeTCPMessage(conn, query); err != nil {
+			t.Fatalf("error enviando la consulta: %v", err)
+		}
+		if response, err = readTCPMessage(conn); err != nil {
+			t.Fatalf("error leyendo la respuesta: %v", err)
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			t.Fatalf("error enviando la consulta: %v", err)
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("error leyendo la respuesta: %v", err)
+		}
+		response = buf[:n]
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		t.Fatalf("respuesta inválida: %v", err)
+	}
+	if msg.Header.ID != 0x2a {
+		t.Fatalf("ID de respuesta = %#x, se esperaba 0x2a", msg.Header.ID)
+	}
+	var addresses [][4]byte
+	for _, answer := range msg.Answers {
+		if a, ok := answer.Body.(*dnsmessage.AResource); ok {
+			addresses = append(addresses, a.A)
+		}
+	}
+	return msg.Header.RCode, addresses
+}
+
+func TestDNSBlockerResolve(t *testing.T) {
+	blocker := newTestDNSBlocker(t, "*.youtube.com", "https://www.facebook.com/login")
+
+	tests := []struct {
+		name  string
+		query string
+		qtype dnsmessage.Type
+		rcode dnsmessage.RCode
+		want  [4]byte
+	}{
+		{"comodín cubre el dominio", "youtube.com", dnsmessage.TypeA, dnsmessage.RCodeSuccess, [4]byte{}},
+		{"comodín cubre subdominios", "m.YouTube.com", dnsmessage.TypeA, dnsmessage.RCodeSuccess, [4]byte{}},
+		{"host exacto", "www.facebook.com", dnsmessage.TypeA, dnsmessage.RCodeSuccess, [4]byte{}},
+		{"otro subdominio del host exacto", "m.facebook.com", dnsmessage.TypeA, dnsmessage.RCodeSuccess, upstreamAddress},
+		{"no bloqueado se reenvía", "example.com", dnsmessage.TypeA, dnsmessage.RCodeSuccess, upstreamAddress},
+		{"otros tipos bloqueados", "youtube.com", dnsmessage.TypeMX, dnsmessage.RCodeNameError, [4]byte{}},
+	}
+	for _, network := range []string{"udp", "tcp"} {
+		for _, tt := range tests {
+			t.Run(network+"/"+tt.name, func(t *testing.T) {
+				rcode, addresses := queryDNS(t, network, blocker.Addr(), tt.query, tt.qtype)
+				if rcode != tt.rcode {
+					t.Fatalf("rcode = %v, se esperaba %v", rcode, tt.rcode)
+				}
+				if tt.qtype != dnsmessage.TypeA {
+					return
+				}
+				if len(addresses) != 1 || addresses[0] != tt.want {
+					t.Errorf("respuestas A = %v, se esperaba %v", addresses, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestDNSBlockerClear(t *testing.T) {
+	blocker := newTestDNSBlocker(t, "youtube.com")
+	if _, addresses := queryDNS(t, "udp", blocker.Addr(), "youtube.com", dnsmessage.TypeA); len(addresses) != 1 || addresses[0] != ([4]byte{}) {
+		t.Fatalf("respuestas A antes de liberar = %v, se esperaba 0.0.0.0", addresses)
+	}
+	if err := blocker.Clear(); err != nil {
+		t.Fatalf("error liberando: %v", err)
+	}
+	if _, addresses := queryDNS(t, "udp", blocker.Addr(), "youtube.com", dnsmessage.TypeA); len(addresses) != 1 || addresses[0] != upstreamAddress {
+		t.Errorf("respuestas A después de liberar = %v, se esperaba %v", addresses, upstreamAddress)
+	}
+}
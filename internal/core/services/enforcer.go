@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// Enforcer aplica una forma de restricción sobre un proceso. Enforce la
+// aplica y Release la deshace; ambas deben tolerar procesos que ya terminaron.
+type Enforcer interface {
+	Name() string
+	Enforce(process ProcessInfo) error
+	Release(process ProcessInfo) error
+}
+
+// EnforcementOptions es la restricción elegida para un proceso por su selector.
+type EnforcementOptions struct {
+	Action      string
+	Relaunch    bool
+	SuspendTree bool
+}
+
+// suspendEnforcer suspende y reanuda el proceso con el ApplicationManager.
+type suspendEnforcer struct {
+	appManager ApplicationManager
+}
+
+func NewSuspendEnforcer(appManager ApplicationManager) Enforcer {
+	return &suspendEnforcer{appManager: appManager}
+}
+
+func (e *suspendEnforcer) Name() string {
+	return domain.EnforcementSuspend
+}
+
+func (e *suspendEnforcer) Enforce(process ProcessInfo) error {
+	return e.appManager.SuspendProcess(process)
+}
+
+func (e *suspendEnforcer) Release(process ProcessInfo) error {
+	return e.appManager.ResumeProcess(process)
+}
+
+// terminateEnforcer termina el proceso. Con relaunch, al liberar lo vuelve a
+// iniciar con la misma ruta y línea de comandos.
+type terminateEnforcer struct {
+	appManager ApplicationManager
+	relaunch   bool
+}
+
+func NewTerminateEnforcer(appManager ApplicationManager, relaunch bool) Enforcer {
+	return &terminateEnforcer{appManager: appManager, relaunch: relaunch}
+}
+
+func (e *terminateEnforcer) Name() string {
+	return domain.EnforcementTerminate
+}
+
+func (e *terminateEnforcer) Enforce(process ProcessInfo) error {
+	return e.appManager.TerminateProcess(process)
+}
+
+func (e *terminateEnforcer) Release(process ProcessInfo) error {
+	if !e.relaunch {
+		return nil
+	}
+	if process.Path == "" {
+		return fmt.Errorf("no se conoce la ruta de %s para volver a iniciarlo", process.Name)
+	}
+	cmd := relaunchCommand(process)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error volviendo a iniciar %s: %v", process.Path, err)
+	}
+	go cmd.Wait()
+	return nil
+}
+
+// NewEnforcer crea el Enforcer para las opciones indicadas. Los que actúan
+// sobre ventanas las registran en ledger para poder restaurarlas.
+func NewEnforcer(options EnforcementOptions, appManager ApplicationManager, ledger *Ledger) (Enforcer, error) {
+	switch options.Action {
+	case "", domain.EnforcementSuspend:
+		return NewSuspendEnforcer(appManager), nil
+	case domain.EnforcementTerminate:
+		return NewTerminateEnforcer(appManager, options.Relaunch), nil
+	case domain.EnforcementMinimize, domain.EnforcementHide:
+		return NewWindowEnforcer(options.Action, ledger)
+	case domain.EnforcementOverlay:
+		return NewOverlayEnforcer(ledger)
+	default:
+		return nil, fmt.Errorf("acción de restricción desconocida: %q", options.Action)
+	}
+}
+
+// enforcerSet conserva un Enforcer por acción, ya que algunos mantienen
+// estado (ventanas ocultas, ventanas de bloqueo).
+type enforcerSet struct {
+	mu         sync.Mutex
+	appManager ApplicationManager
+	ledger     *Ledger
+	enforcers  map[EnforcementOptions]Enforcer
+	// processOnly limita las acciones a las que actúan sobre el proceso. En
+	// el modo multisesión el agente no ve el escritorio de las otras sesiones
+	// ni puede relanzar un programa como su usuario.
+	processOnly bool
+}
+
+func newEnforcerSet(appManager ApplicationManager, ledger *Ledger) *enforcerSet {
+	return &enforcerSet{appManager: appManager, ledger: ledger, enforcers: make(map[EnforcementOptions]Enforcer)}
+}
+
+// newSessionEnforcerSet crea el conjunto de Enforcers del modo multisesión:
+// las acciones sobre ventanas se reemplazan por la suspensión y la
+// terminación no relanza.
+func newSessionEnforcerSet(appManager ApplicationManager, ledger *Ledger) *enforcerSet {
+	set := newEnforcerSet(appManager, ledger)
+	set.processOnly = true
+	return set
+}
+
+// effective retorna las opciones que realmente se aplican con este conjunto.
+func (s *enforcerSet) effective(options EnforcementOptions) EnforcementOptions {
+	if s.processOnly {
+		options.Relaunch = false
+		if options.Action != domain.EnforcementTerminate {
+			options.Action = domain.EnforcementSuspend
+		}
+	}
+	return options
+}
+
+// get retorna el Enforcer de las opciones. Si la acción no está disponible
+// en esta plataforma se usa la suspensión.
+func (s *enforcerSet) get(options EnforcementOptions) Enforcer {
+	options = s.effective(options)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := EnforcementOptions{Action: options.Action, Relaunch: options.Relaunch}
+	if enforcer, ok := s.enforcers[key]; ok {
+		return enforcer
+	}
+	enforcer, err := NewEnforcer(key, s.appManager, s.ledger)
+	if err != nil {
+		log.Printf("Acción %q no disponible, se usa la suspensión: %v\n", options.Action, err)
+		enforcer = NewSuspendEnforcer(s.appManager)
+	}
+	s.enforcers[key] = enforcer
+	return enforcer
+}
+
+// close detiene los Enforcers que mantienen hilos o revisiones periódicas.
+// Se llama al detener el monitor, después de liberar lo restringido.
+func (s *enforcerSet) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, enforcer := range s.enforcers {
+		if closer, ok := enforcer.(interface{ Close() }); ok {
+			closer.Close()
+		}
+		delete(s.enforcers, key)
+	}
+}
+
+// enforcedGroup es un proceso restringido junto con los descendientes a los
+// que se extendió la restricción, en el orden en que se aplicó.
+type enforcedGroup struct {
+	Enforcer Enforcer
+	Members  []ProcessInfo
+}
+
+// enforcementRegistry recuerda qué se restringió y con qué Enforcer, para
+// liberarlo aunque el árbol o los selectores hayan cambiado, o el proceso ya
+// no exista (p. ej. si se terminó y debe volver a iniciarse). Cada proceso
+// restringido queda además en ledger hasta que se libera.
+type enforcementRegistry struct {
+	mu     sync.Mutex
+	ledger *Ledger
+	groups map[uint32]*enforcedGroup
+}
+
+func newEnforcementRegistry(ledger *Ledger) *enforcementRegistry {
+	return &enforcementRegistry{ledger: ledger, groups: make(map[uint32]*enforcedGroup)}
+}
+
+// record agrega al grupo de root los procesos que aún no tenía y retorna solo
+// esos, que son los que deben restringirse: así cada proceso se restringe una
+// sola vez por grupo aunque el monitor vuelva a aplicar el veredicto. Si root
+// estaba restringido con otro Enforcer, retorna el grupo anterior para que se
+// libere.
+func (r *enforcementRegistry) record(root ProcessInfo, enforcer Enforcer, members []ProcessInfo) (added []ProcessInfo, replaced *enforcedGroup) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	group := r.groups[root.ID]
+	if group != nil && (!group.Members[0].SameProcess(root) || group.Enforcer != enforcer) {
+		replaced = group
+		group = nil
+	}
+	if group == nil {
+		group = &enforcedGroup{Enforcer: enforcer}
+		r.groups[root.ID] = group
+	}
+	for _, process := range members {
+		known := false
+		for _, existing := range group.Members {
+			if existing.SameProcess(process) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			group.Members = append(group.Members, process)
+			added = append(added, process)
+		}
+	}
+	return added, replaced
+}
+
+// drop quita del grupo de root un proceso que no se pudo restringir, para
+// que record lo vuelva a entregar en el próximo ciclo. Si el proceso es la
+// raíz se descarta el grupo completo.
+func (r *enforcementRegistry) drop(root, member ProcessInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	group := r.groups[root.ID]
+	if group == nil || !group.Members[0].SameProcess(root) {
+		return
+	}
+	if member.SameProcess(root) {
+		delete(r.groups, root.ID)
+		return
+	}
+	for i, existing := range group.Members {
+		if existing.SameProcess(member) {
+			group.Members = append(group.Members[:i], group.Members[i+1:]...)
+			return
+		}
+	}
+}
+
+// takeByName retorna y olvida los grupos cuya raíz tiene el nombre indicado.
+func (r *enforcementRegistry) takeByName(name string) []enforcedGroup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var groups []enforcedGroup
+	for id, group := range r.groups {
+		if strings.EqualFold(group.Members[0].Name, name) {
+			groups = append(groups, *group)
+			delete(r.groups, id)
+		}
+	}
+	return groups
+}
+
+// takeAll retorna y olvida todos los grupos.
+func (r *enforcementRegistry) takeAll() []enforcedGroup {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	groups := make([]enforcedGroup, 0, len(r.groups))
+	for id, group := range r.groups {
+		groups = append(groups, *group)
+		delete(r.groups, id)
+	}
+	return groups
+}
+
+// contains indica si el proceso pertenece a algún grupo restringido.
+func (r *enforcementRegistry) contains(process ProcessInfo) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, group := range r.groups {
+		for _, member := range group.Members {
+			if member.SameProcess(process) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// names retorna los nombres de las raíces restringidas. El monitor los
+// mantiene como objetivos de la política aunque el proceso ya no exista, para
+// que un proceso terminado no se considere liberado (y se vuelva a iniciar)
+// hasta que la política lo libere.
+func (r *enforcementRegistry) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, group := range r.groups {
+		names = append(names, group.Members[0].Name)
+	}
+	return names
+}
+
+// restricted retorna los procesos restringidos con la acción de su grupo,
+// ordenados por PID.
+func (r *enforcementRegistry) restricted() []RestrictedProcess {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var processes []RestrictedProcess
+	for _, group := range r.groups {
+		for _, member := range group.Members {
+			processes = append(processes, RestrictedProcess{Name: member.Name, PID: member.ID, Action: group.Enforcer.Name()})
+		}
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+	return processes
+}
+
+// releaseOrder retorna los miembros en el orden en que deben liberarse: los
+// descendientes más profundos primero y la raíz al final. Un proceso
+// terminado se vuelve a iniciar solo desde la raíz, que recrea sus hijos.
+func (g enforcedGroup) releaseOrder() []ProcessInfo {
+	if g.Enforcer.Name() == domain.EnforcementTerminate {
+		return g.Members[:1]
+	}
+	reversed := make([]ProcessInfo, len(g.Members))
+	for i, process := range g.Members {
+		reversed[len(g.Members)-1-i] = process
+	}
+	return reversed
+}
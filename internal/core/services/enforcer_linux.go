@@ -0,0 +1,35 @@
+package services
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// NewWindowEnforcer no está disponible en Linux: no hay una API de ventanas
+// común a todos los entornos de escritorio.
+func NewWindowEnforcer(action string, ledger *Ledger) (Enforcer, error) {
+	return nil, fmt.Errorf("la acción %q no está disponible en Linux", action)
+}
+
+// NewOverlayEnforcer no está disponible en Linux.
+func NewOverlayEnforcer(ledger *Ledger) (Enforcer, error) {
+	return nil, fmt.Errorf("la ventana de bloqueo no está disponible en Linux")
+}
+
+// relaunchCommand arma el comando para volver a iniciar el proceso. La línea
+// de comandos de /proc pierde los límites entre argumentos con espacios.
+func relaunchCommand(process ProcessInfo) *exec.Cmd {
+	var args []string
+	if fields := strings.Fields(process.CommandLine); len(fields) > 1 {
+		args = fields[1:]
+	}
+	cmd := exec.Command(process.Path, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	return cmd
+}
+
+// restoreWindows no hace nada en Linux: las acciones sobre ventanas no están
+// disponibles.
+func restoreWindows(pid uint32, handles []uint64) {}
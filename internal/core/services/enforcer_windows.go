@@ -0,0 +1,486 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	user32 = windows.NewLazySystemDLL("user32.dll")
+	gdi32  = windows.NewLazySystemDLL("gdi32.dll")
+
+	procGetWindow        = user32.NewProc("GetWindow")
+	procShowWindow       = user32.NewProc("ShowWindow")
+	procEnableWindow     = user32.NewProc("EnableWindow")
+	procGetWindowRect    = user32.NewProc("GetWindowRect")
+	procIsIconic         = user32.NewProc("IsIconic")
+	procRegisterClassEx  = user32.NewProc("RegisterClassExW")
+	procCreateWindowEx   = user32.NewProc("CreateWindowExW")
+	procDestroyWindow    = user32.NewProc("DestroyWindow")
+	procDefWindowProc    = user32.NewProc("DefWindowProcW")
+	procSetWindowPos     = user32.NewProc("SetWindowPos")
+	procPeekMessage      = user32.NewProc("PeekMessageW")
+	procTranslateMessage = user32.NewProc("TranslateMessage")
+	procDispatchMessage  = user32.NewProc("DispatchMessageW")
+	procBeginPaint       = user32.NewProc("BeginPaint")
+	procEndPaint         = user32.NewProc("EndPaint")
+	procDrawText         = user32.NewProc("DrawTextW")
+	procGetClientRect    = user32.NewProc("GetClientRect")
+	procCreateSolidBrush = gdi32.NewProc("CreateSolidBrush")
+	procSetTextColor     = gdi32.NewProc("SetTextColor")
+	procSetBkMode        = gdi32.NewProc("SetBkMode")
+)
+
+const (
+	gwOwner          = 4
+	swHide           = 0
+	swShowNoActivate = 4
+	swMinimize       = 6
+
+	wmClose   = 0x0010
+	wmPaint   = 0x000F
+	pmRemove  = 0x0001
+	wsPopup   = 0x80000000
+	wsExTop   = 0x00000008
+	wsExTool  = 0x00000080
+	swpNoAct  = 0x0010
+	swpShow   = 0x0040
+	swpHide   = 0x0080
+	bkTransp  = 1
+	dtCenter  = 0x0001
+	dtVCenter = 0x0004
+	dtSingle  = 0x0020
+
+	overlayClassName = "ScrapeBlockerOverlay"
+	overlayMessage   = "Aplicación bloqueada mientras no tengas una interacción en curso - ScrapeBlocker"
+	// overlayColor es #1b0088 en formato COLORREF (0x00BBGGRR).
+	overlayColor = 0x0088001B
+	hwndTopmost  = ^uintptr(0)
+)
+
+var (
+	enumWindowsMu       sync.Mutex
+	enumWindowsPID      uint32
+	enumWindowsResult   []windows.HWND
+	enumWindowsCallback = windows.NewCallback(func(hwnd windows.HWND, _ uintptr) uintptr {
+		var pid uint32
+		windows.GetWindowThreadProcessId(hwnd, &pid)
+		if pid == enumWindowsPID && windows.IsWindowVisible(hwnd) {
+			if owner, _, _ := procGetWindow.Call(uintptr(hwnd), gwOwner); owner == 0 {
+				enumWindowsResult = append(enumWindowsResult, hwnd)
+			}
+		}
+		return 1
+	})
+)
+
+// topLevelWindows retorna las ventanas principales visibles del proceso.
+func topLevelWindows(pid uint32) []windows.HWND {
+	enumWindowsMu.Lock()
+	defer enumWindowsMu.Unlock()
+	enumWindowsPID = pid
+	enumWindowsResult = nil
+	windows.EnumWindows(enumWindowsCallback, nil)
+	return enumWindowsResult
+}
+
+// windowEnforcer minimiza u oculta las ventanas principales del proceso y las
+// vuelve a aplicar periódicamente, ya que el usuario puede restaurarlas desde
+// la barra de tareas o el proceso puede abrir ventanas nuevas. La revisión
+// periódica solo corre mientras haya procesos restringidos.
+type windowEnforcer struct {
+	action string
+	ledger *Ledger
+
+	mu        sync.Mutex
+	processes map[uint32]ProcessInfo
+	affected  map[uint32]map[windows.HWND]bool
+	stop      chan struct{}
+}
+
+// NewWindowEnforcer crea el Enforcer que minimiza ("minimize") u oculta
+// ("hide") las ventanas del proceso sin detenerlo. Las ventanas afectadas se
+// registran en ledger.
+func NewWindowEnforcer(action string, ledger *Ledger) (Enforcer, error) {
+	if action != domain.EnforcementMinimize && action != domain.EnforcementHide {
+		return nil, fmt.Errorf("acción de ventanas desconocida: %q", action)
+	}
+	e := &windowEnforcer{
+		action:    action,
+		ledger:    ledger,
+		processes: make(map[uint32]ProcessInfo),
+		affected:  make(map[uint32]map[windows.HWND]bool),
+	}
+	return e, nil
+}
+
+func (e *windowEnforcer) Name() string {
+	return e.action
+}
+
+func (e *windowEnforcer) Enforce(process ProcessInfo) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.processes[process.ID] = process
+	if e.affected[process.ID] == nil {
+		e.affected[process.ID] = make(map[windows.HWND]bool)
+	}
+	e.applyLocked(process.ID)
+	if e.stop == nil {
+		e.stop = make(chan struct{})
+		go e.reapply(time.Second, e.stop)
+	}
+	return nil
+}
+
+func (e *windowEnforcer) Release(process ProcessInfo) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for hwnd := range e.affected[process.ID] {
+		if windows.IsWindow(hwnd) {
+			procEnableWindow.Call(uintptr(hwnd), 1)
+			procShowWindow.Call(uintptr(hwnd), swShowNoActivate)
+		}
+	}
+	delete(e.processes, process.ID)
+	delete(e.affected, process.ID)
+	if len(e.processes) == 0 {
+		e.stopLocked()
+	}
+	return nil
+}
+
+// Close detiene la revisión periódica. Las ventanas afectadas se restauran
+// con Release antes de cerrar.
+func (e *windowEnforcer) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopLocked()
+}
+
+func (e *windowEnforcer) stopLocked() {
+	if e.stop != nil {
+		close(e.stop)
+		e.stop = nil
+	}
+}
+
+// applyLocked minimiza u oculta las ventanas visibles del proceso. Las
+// ventanas ocultas dejan de ser visibles, así que se recuerdan para restaurarlas.
+func (e *windowEnforcer) applyLocked(pid uint32) {
+	for _, hwnd := range topLevelWindows(pid) {
+		if !e.affected[pid][hwnd] {
+			if err := e.ledger.RecordWindow(pid, uint64(hwnd)); err != nil {
+				log.Printf("Error registrando la ventana %x: %v\n", hwnd, err)
+			}
+			e.affected[pid][hwnd] = true
+		}
+		if e.action == domain.EnforcementHide {
+			procShowWindow.Call(uintptr(hwnd), swHide)
+		} else if iconic, _, _ := procIsIconic.Call(uintptr(hwnd)); iconic == 0 {
+			procShowWindow.Call(uintptr(hwnd), swMinimize)
+		}
+	}
+}
+
+func (e *windowEnforcer) reapply(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		e.mu.Lock()
+		for pid := range e.processes {
+			e.applyLocked(pid)
+		}
+		e.mu.Unlock()
+	}
+}
+
+// overlayEnforcer cubre las ventanas del proceso con una ventana de bloqueo
+// siempre visible y deshabilita la entrada de teclado y mouse de esas
+// ventanas. Las ventanas de Win32 pertenecen al hilo que las crea, así que
+// todas se crean y mueven desde un único hilo con su propio bucle de mensajes.
+type overlayEnforcer struct {
+	ledger    *Ledger
+	commands  chan overlayCommand
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type overlayCommand struct {
+	process ProcessInfo
+	enforce bool
+	done    chan error
+}
+
+type overlayState struct {
+	process  ProcessInfo
+	overlay  windows.HWND
+	disabled map[windows.HWND]bool
+}
+
+type paintStruct struct {
+	hdc       windows.Handle
+	erase     int32
+	paint     windows.Rect
+	restore   int32
+	incUpdate int32
+	reserved  [32]byte
+}
+
+type wndClassEx struct {
+	size       uint32
+	style      uint32
+	wndProc    uintptr
+	clsExtra   int32
+	wndExtra   int32
+	instance   windows.Handle
+	icon       windows.Handle
+	cursor     windows.Handle
+	background windows.Handle
+	menuName   *uint16
+	className  *uint16
+	iconSm     windows.Handle
+}
+
+type winMessage struct {
+	hwnd    windows.HWND
+	message uint32
+	wParam  uintptr
+	lParam  uintptr
+	time    uint32
+	pt      struct{ x, y int32 }
+	private uint32
+}
+
+var overlayWndProc = windows.NewCallback(func(hwnd windows.HWND, msg uint32, wParam, lParam uintptr) uintptr {
+	switch msg {
+	case wmClose:
+		// La ventana de bloqueo solo se cierra al liberar el proceso.
+		return 0
+	case wmPaint:
+		var ps paintStruct
+		hdc, _, _ := procBeginPaint.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&ps)))
+		var rect windows.Rect
+		procGetClientRect.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&rect)))
+		procSetTextColor.Call(hdc, 0x00FFFFFF)
+		procSetBkMode.Call(hdc, bkTransp)
+		text, _ := windows.UTF16PtrFromString(overlayMessage)
+		procDrawText.Call(hdc, uintptr(unsafe.Pointer(text)), ^uintptr(0), uintptr(unsafe.Pointer(&rect)), dtCenter|dtVCenter|dtSingle)
+		procEndPaint.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&ps)))
+		return 0
+	}
+	ret, _, _ := procDefWindowProc.Call(uintptr(hwnd), uintptr(msg), wParam, lParam)
+	return ret
+})
+
+// NewOverlayEnforcer crea el Enforcer que cubre el proceso con una ventana de
+// bloqueo. Las ventanas deshabilitadas se registran en ledger.
+func NewOverlayEnforcer(ledger *Ledger) (Enforcer, error) {
+	e := &overlayEnforcer{ledger: ledger, commands: make(chan overlayCommand), stop: make(chan struct{})}
+	ready := make(chan error)
+	go e.run(ready)
+	if err := <-ready; err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *overlayEnforcer) Name() string {
+	return domain.EnforcementOverlay
+}
+
+func (e *overlayEnforcer) Enforce(process ProcessInfo) error {
+	done := make(chan error)
+	e.commands <- overlayCommand{process: process, enforce: true, done: done}
+	return <-done
+}
+
+func (e *overlayEnforcer) Release(process ProcessInfo) error {
+	done := make(chan error)
+	e.commands <- overlayCommand{process: process, done: done}
+	return <-done
+}
+
+// Close termina el hilo de la interfaz y destruye las ventanas de bloqueo que
+// queden. No debe usarse el Enforcer después de cerrarlo.
+func (e *overlayEnforcer) Close() {
+	e.closeOnce.Do(func() { close(e.stop) })
+}
+
+// run es el hilo de la interfaz: registra la clase de ventana, atiende los
+// comandos y mantiene cada ventana de bloqueo sobre las del proceso.
+func (e *overlayEnforcer) run(ready chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	var instance windows.Handle
+	if err := windows.GetModuleHandleEx(0, nil, &instance); err != nil {
+		ready <- fmt.Errorf("error obteniendo el módulo: %v", err)
+		return
+	}
+	className, _ := windows.UTF16PtrFromString(overlayClassName)
+	brush, _, _ := procCreateSolidBrush.Call(overlayColor)
+	class := wndClassEx{
+		wndProc:    overlayWndProc,
+		instance:   instance,
+		background: windows.Handle(brush),
+		className:  className,
+	}
+	class.size = uint32(unsafe.Sizeof(class))
+	if atom, _, err := procRegisterClassEx.Call(uintptr(unsafe.Pointer(&class))); atom == 0 {
+		ready <- fmt.Errorf("error registrando la ventana de bloqueo: %v", err)
+		return
+	}
+	ready <- nil
+
+	states := make(map[uint32]*overlayState)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case cmd := <-e.commands:
+			if cmd.enforce {
+				cmd.done <- e.enforce(states, cmd.process, instance, className)
+			} else {
+				cmd.done <- e.release(states, cmd.process)
+			}
+		case <-ticker.C:
+		case <-e.stop:
+			for _, state := range states {
+				e.release(states, state.process)
+			}
+			return
+		}
+		for _, state := range states {
+			e.follow(state)
+		}
+		var msg winMessage
+		for {
+			if ok, _, _ := procPeekMessage.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0, pmRemove); ok == 0 {
+				break
+			}
+			procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
+			procDispatchMessage.Call(uintptr(unsafe.Pointer(&msg)))
+		}
+	}
+}
+
+func (e *overlayEnforcer) enforce(states map[uint32]*overlayState, process ProcessInfo, instance windows.Handle, className *uint16) error {
+	if _, ok := states[process.ID]; ok {
+		return nil
+	}
+	title, _ := windows.UTF16PtrFromString("ScrapeBlocker")
+	hwnd, _, err := procCreateWindowEx.Call(
+		wsExTop|wsExTool,
+		uintptr(unsafe.Pointer(className)),
+		uintptr(unsafe.Pointer(title)),
+		wsPopup,
+		0, 0, 0, 0,
+		0, 0, uintptr(instance), 0,
+	)
+	if hwnd == 0 {
+		return fmt.Errorf("error creando la ventana de bloqueo: %v", err)
+	}
+	state := &overlayState{process: process, overlay: windows.HWND(hwnd), disabled: make(map[windows.HWND]bool)}
+	states[process.ID] = state
+	e.follow(state)
+	return nil
+}
+
+func (e *overlayEnforcer) release(states map[uint32]*overlayState, process ProcessInfo) error {
+	state, ok := states[process.ID]
+	if !ok {
+		return nil
+	}
+	for hwnd := range state.disabled {
+		if windows.IsWindow(hwnd) {
+			procEnableWindow.Call(uintptr(hwnd), 1)
+		}
+	}
+	procDestroyWindow.Call(uintptr(state.overlay))
+	delete(states, process.ID)
+	return nil
+}
+
+// follow ubica la ventana de bloqueo sobre el rectángulo que ocupan las
+// ventanas del proceso y deshabilita las ventanas nuevas. Si el proceso no
+// tiene ventanas visibles la ventana de bloqueo se oculta.
+func (e *overlayEnforcer) follow(state *overlayState) {
+	var bounds windows.Rect
+	found := false
+	for _, hwnd := range topLevelWindows(state.process.ID) {
+		if !state.disabled[hwnd] {
+			if err := e.ledger.RecordWindow(state.process.ID, uint64(hwnd)); err != nil {
+				log.Printf("Error registrando la ventana %x: %v\n", hwnd, err)
+			}
+			procEnableWindow.Call(uintptr(hwnd), 0)
+			state.disabled[hwnd] = true
+		}
+		if iconic, _, _ := procIsIconic.Call(uintptr(hwnd)); iconic != 0 {
+			continue
+		}
+		var rect windows.Rect
+		procGetWindowRect.Call(uintptr(hwnd), uintptr(unsafe.Pointer(&rect)))
+		if !found {
+			bounds = rect
+			found = true
+			continue
+		}
+		bounds.Left = min(bounds.Left, rect.Left)
+		bounds.Top = min(bounds.Top, rect.Top)
+		bounds.Right = max(bounds.Right, rect.Right)
+		bounds.Bottom = max(bounds.Bottom, rect.Bottom)
+	}
+
+	if !found {
+		procSetWindowPos.Call(uintptr(state.overlay), 0, 0, 0, 0, 0, swpNoAct|swpHide)
+		return
+	}
+	procSetWindowPos.Call(uintptr(state.overlay), hwndTopmost,
+		uintptr(bounds.Left), uintptr(bounds.Top),
+		uintptr(bounds.Right-bounds.Left), uintptr(bounds.Bottom-bounds.Top),
+		swpNoAct|swpShow)
+}
+
+// restoreWindows habilita y vuelve a mostrar las ventanas registradas que
+// sigan perteneciendo al proceso.
+func restoreWindows(pid uint32, handles []uint64) {
+	for _, handle := range handles {
+		hwnd := windows.HWND(handle)
+		var owner uint32
+		if !windows.IsWindow(hwnd) {
+			continue
+		}
+		if windows.GetWindowThreadProcessId(hwnd, &owner); owner != pid {
+			continue
+		}
+		procEnableWindow.Call(uintptr(hwnd), 1)
+		procShowWindow.Call(uintptr(hwnd), swShowNoActivate)
+	}
+}
+
+// relaunchCommand arma el comando para volver a iniciar el proceso con su
+// línea de comandos original, desde la carpeta del ejecutable.
+func relaunchCommand(process ProcessInfo) *exec.Cmd {
+	cmd := exec.Command(process.Path)
+	cmd.Dir = filepath.Dir(process.Path)
+	if process.CommandLine != "" {
+		cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: process.CommandLine}
+	}
+	return cmd
+}
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hostsBeginMarker = "# BEGIN ScrapeBlocker"
+	hostsEndMarker   = "# END ScrapeBlocker"
+	hostsNotice      = "# Sección administrada por ScrapeBlocker. No editar manualmente."
+	hostsBlockIP     = "0.0.0.0"
+)
+
+var defaultHostsManager = NewHostsManager(DefaultHostsPath, defaultLedger)
+
+// HostsManager administra una sección delimitada del archivo hosts. Todo lo
+// que esté fuera de la sección (entradas de TI, comentarios) se conserva
+// intacto, y cada escritura es atómica (archivo temporal + rename).
+type HostsManager struct {
+	path   string
+	ledger *Ledger
+	mu     sync.Mutex
+
+	// expected es el último conjunto aplicado por este proceso y lastHash el
+	// hash del archivo tras esa escritura; se usan para detectar manipulaciones.
+	expected    []string
+	hasExpected bool
+	lastHash    [sha256.Size]byte
+}
+
+// NewHostsManager crea un administrador para el archivo hosts indicado. Las
+// entradas escritas se registran en ledger antes de cada escritura.
+func NewHostsManager(path string, ledger *Ledger) *HostsManager {
+	return &HostsManager{path: path, ledger: ledger}
+}
+
+// Path retorna la ruta del archivo hosts administrado.
+func (m *HostsManager) Path() string {
+	return m.path
+}
+
+// Apply reemplaza el contenido de la sección administrada por el conjunto de
+// hosts indicado. Un conjunto vacío elimina la sección.
+func (m *HostsManager) Apply(hosts []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := m.read()
+	if err != nil {
+		return err
+	}
+	file.managed = normalizeHostSet(hosts)
+	if err := m.ledger.RecordHosts(m.path, file.managed); err != nil {
+		log.Printf("Error registrando las entradas de hosts: %v\n", err)
+	}
+	if err := m.write(file); err != nil {
+		return err
+	}
+	m.expected = file.managed
+	m.hasExpected = true
+	return nil
+}
+
+// Clear elimina la sección administrada del archivo hosts.
+func (m *HostsManager) Clear() error {
+	return m.Apply(nil)
+}
+
+// Current retorna los hosts presentes en la sección administrada.
+func (m *HostsManager) Current() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := m.read()
+	if err != nil {
+		return nil, err
+	}
+	return file.managed, nil
+}
+
+// CheckTamper compara la sección administrada con el último conjunto
+// aplicado y, si difiere, la restaura. Retorna nil si no hubo manipulación o
+// si el proceso aún no ha aplicado ningún conjunto. Para que la revisión
+// periódica sea barata, el archivo solo se interpreta si su hash cambió.
+func (m *HostsManager) CheckTamper() (*HostsTamperEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.hasExpected {
+		return nil, nil
+	}
+
+	content, err := os.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error al leer el archivo hosts: %v", err)
+	}
+	if sha256.Sum256(content) == m.lastHash {
+		return nil, nil
+	}
+
+	file, err := m.read()
+	if err != nil {
+		return nil, err
+	}
+	if equalHostSets(file.managed, m.expected) {
+		m.lastHash = sha256.Sum256(content)
+		return nil, nil
+	}
+
+	event := &HostsTamperEvent{
+		Path:       m.path,
+		Expected:   m.expected,
+		Found:      file.managed,
+		DetectedAt: time.Now(),
+	}
+	file.managed = m.expected
+	if err := m.write(file); err != nil {
+		event.Error = err.Error()
+		return event, err
+	}
+	event.Restored = true
+	return event, nil
+}
+
+// hostsFile es el archivo hosts separado en lo que está antes y después de
+// la sección administrada y los hosts que contiene dicha sección.
+type hostsFile struct {
+	before       []string
+	after        []string
+	managed      []string
+	lineEnding   string
+	finalNewline bool
+}
+
+func (m *HostsManager) read() (hostsFile, error) {
+	file := hostsFile{lineEnding: defaultLineEnding(), finalNewline: true}
+
+	content, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return file, nil
+	}
+	if err != nil {
+		return file, fmt.Errorf("error al leer el archivo hosts: %v", err)
+	}
+	if len(content) == 0 {
+		return file, nil
+	}
+
+	text := string(content)
+	if strings.Contains(text, "\r\n") {
+		file.lineEnding = "\r\n"
+	} else {
+		file.lineEnding = "\n"
+	}
+	file.finalNewline = strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+
+	lines := strings.Split(text, "\n")
+	begin, end := -1, -1
+	for i := range lines {
+		lines[i] = strings.TrimSuffix(lines[i], "\r")
+		trimmed := strings.TrimSpace(lines[i])
+		if begin < 0 && trimmed == hostsBeginMarker {
+			begin = i
+		} else if begin >= 0 && end < 0 && trimmed == hostsEndMarker {
+			end = i
+		}
+	}
+	if begin < 0 {
+		file.before = lines
+		return file, nil
+	}
+
+	file.before = lines[:begin]
+	var section []string
+	if end >= 0 {
+		section = lines[begin+1 : end]
+		file.after = lines[end+1:]
+	} else {
+		// Sin marcador de fin (editado a mano o truncado) la sección termina
+		// en la primera línea que no es una entrada bloqueada; lo que sigue
+		// no es nuestro y se conserva intacto.
+		stop := begin + 1
+		for stop < len(lines) && isManagedHostsLine(lines[stop]) {
+			stop++
+		}
+		section = lines[begin+1 : stop]
+		file.after = lines[stop:]
+	}
+
+	for _, line := range section {
+		fields := hostsLineFields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		file.managed = append(file.managed, fields[1:]...)
+	}
+	file.managed = normalizeHostSet(file.managed)
+	return file, nil
+}
+
+// isManagedHostsLine indica si la línea es de las que escribe ScrapeBlocker
+// dentro de la sección: el aviso o una entrada 0.0.0.0.
+func isManagedHostsLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == hostsNotice {
+		return true
+	}
+	fields := hostsLineFields(trimmed)
+	return len(fields) >= 2 && fields[0] == hostsBlockIP
+}
+
+// hostsLineFields separa los campos de una línea del archivo hosts sin el
+// comentario, que va desde el primer campo que empieza con #.
+func hostsLineFields(line string) []string {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if strings.HasPrefix(field, "#") {
+			return fields[:i]
+		}
+	}
+	return fields
+}
+
+func (m *HostsManager) write(file hostsFile) error {
+	lines := append([]string{}, file.before...)
+	if len(file.managed) > 0 {
+		lines = append(lines, hostsBeginMarker, hostsNotice)
+		for _, host := range file.managed {
+			lines = append(lines, hostsBlockIP+" "+host)
+		}
+		lines = append(lines, hostsEndMarker)
+	}
+	lines = append(lines, file.after...)
+
+	output := strings.Join(lines, file.lineEnding)
+	if file.finalNewline && len(lines) > 0 {
+		output += file.lineEnding
+	}
+
+	if err := writeFileAtomic(m.path, []byte(output)); err != nil {
+		return err
+	}
+	m.lastHash = sha256.Sum256([]byte(output))
+	return nil
+}
+
+// writeFileAtomic escribe en un temporal del mismo directorio y lo renombra
+// sobre el destino, de forma que nunca quede un archivo a medio escribir.
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".scrapeblocker-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error al crear el archivo temporal: %v", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error al escribir el archivo temporal: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error al sincronizar el archivo temporal: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error al cerrar el archivo temporal: %v", err)
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return fmt.Errorf("error al ajustar permisos del archivo temporal: %v", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error al reemplazar el archivo %s: %v", path, err)
+	}
+	return nil
+}
+
+// normalizeHostSet elimina duplicados y vacíos y ordena los hosts.
+func normalizeHostSet(hosts []string) []string {
+	seen := make(map[string]bool, len(hosts))
+	var result []string
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if host == "" || seen[host] {
+			continue
+		}
+		seen[host] = true
+		result = append(result, host)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func equalHostSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func defaultLineEnding() string {
+	if runtime.GOOS == "windows" {
+		return "\r\n"
+	}
+	return "\n"
+}
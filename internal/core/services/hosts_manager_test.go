@@ -0,0 +1,173 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTempHosts crea un archivo hosts temporal con el contenido indicado,
+// registrado en un ledger temporal.
+func writeTempHosts(t *testing.T, content string) *HostsManager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("error escribiendo el hosts temporal: %v", err)
+	}
+	return NewHostsManager(path, newTestLedger(t))
+}
+
+func readTempHosts(t *testing.T, manager *HostsManager) string {
+	t.Helper()
+	content, err := os.ReadFile(manager.Path())
+	if err != nil {
+		t.Fatalf("error leyendo el hosts temporal: %v", err)
+	}
+	return string(content)
+}
+
+func TestHostsManagerRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		managed []string
+		clear   string
+	}{
+		{
+			name:    "sin sección",
+			content: "127.0.0.1 localhost\n10.0.0.5 intranet\n",
+			clear:   "127.0.0.1 localhost\n10.0.0.5 intranet\n",
+		},
+		{
+			name: "sección completa",
+			content: "127.0.0.1 localhost\n" + hostsBeginMarker + "\n" + hostsNotice + "\n0.0.0.0 b.com\n0.0.0.0 a.com\n" +
+				hostsEndMarker + "\n10.0.0.5 intranet\n",
+			managed: []string{"a.com", "b.com"},
+			clear:   "127.0.0.1 localhost\n10.0.0.5 intranet\n",
+		},
+		{
+			name: "sin marcador de fin conserva las líneas de TI",
+			content: "127.0.0.1 localhost\n" + hostsBeginMarker + "\n" + hostsNotice + "\n0.0.0.0 a.com\n" +
+				"10.0.0.5 intranet\n# comentario de TI\n0.0.0.0 ti-bloqueado.com\n",
+			managed: []string{"a.com"},
+			clear:   "127.0.0.1 localhost\n10.0.0.5 intranet\n# comentario de TI\n0.0.0.0 ti-bloqueado.com\n",
+		},
+		{
+			name:    "sin marcador de fin hasta el final",
+			content: "127.0.0.1 localhost\n" + hostsBeginMarker + "\n0.0.0.0 a.com\n0.0.0.0 b.com",
+			managed: []string{"a.com", "b.com"},
+			clear:   "127.0.0.1 localhost",
+		},
+		{
+			name: "comentario al final de una entrada",
+			content: hostsBeginMarker + "\n0.0.0.0 a.com # agregado por TI\n0.0.0.0 b.com #c.com\n0.0.0.0 # sin host\n" +
+				hostsEndMarker + "\n",
+			managed: []string{"a.com", "b.com"},
+		},
+		{
+			name: "CRLF",
+			content: "127.0.0.1 localhost\r\n" + hostsBeginMarker + "\r\n" + hostsNotice + "\r\n0.0.0.0 a.com\r\n" +
+				hostsEndMarker + "\r\n10.0.0.5 intranet\r\n",
+			managed: []string{"a.com"},
+			clear:   "127.0.0.1 localhost\r\n10.0.0.5 intranet\r\n",
+		},
+		{
+			name:    "sin salto de línea final",
+			content: "127.0.0.1 localhost\n" + hostsBeginMarker + "\n0.0.0.0 a.com\n" + hostsEndMarker + "\n10.0.0.5 intranet",
+			managed: []string{"a.com"},
+			clear:   "127.0.0.1 localhost\n10.0.0.5 intranet",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := writeTempHosts(t, tt.content)
+			managed, err := manager.Current()
+			if err != nil {
+				t.Fatalf("error leyendo la sección: %v", err)
+			}
+			if !reflect.DeepEqual(managed, tt.managed) {
+				t.Errorf("sección = %v, se esperaba %v", managed, tt.managed)
+			}
+			if err := manager.Clear(); err != nil {
+				t.Fatalf("error limpiando la sección: %v", err)
+			}
+			if got := readTempHosts(t, manager); got != tt.clear {
+				t.Errorf("contenido tras limpiar = %q, se esperaba %q", got, tt.clear)
+			}
+		})
+	}
+}
+
+func TestHostsManagerApplyPreservesFormat(t *testing.T) {
+	manager := writeTempHosts(t, "127.0.0.1 localhost\r\n10.0.0.5 intranet")
+
+	if err := manager.Apply([]string{"b.com", "a.com", "a.com"}); err != nil {
+		t.Fatalf("error aplicando: %v", err)
+	}
+	want := "127.0.0.1 localhost\r\n10.0.0.5 intranet\r\n" + hostsBeginMarker + "\r\n" + hostsNotice +
+		"\r\n0.0.0.0 a.com\r\n0.0.0.0 b.com\r\n" + hostsEndMarker
+	if got := readTempHosts(t, manager); got != want {
+		t.Errorf("contenido = %q, se esperaba %q", got, want)
+	}
+	if path, hosts := manager.ledger.Hosts(); path != manager.Path() || !reflect.DeepEqual(hosts, []string{"a.com", "b.com"}) {
+		t.Errorf("registro = %s %v, se esperaba %s [a.com b.com]", path, hosts, manager.Path())
+	}
+
+	if err := manager.Apply([]string{"c.com"}); err != nil {
+		t.Fatalf("error reaplicando: %v", err)
+	}
+	managed, err := manager.Current()
+	if err != nil {
+		t.Fatalf("error leyendo la sección: %v", err)
+	}
+	if !reflect.DeepEqual(managed, []string{"c.com"}) {
+		t.Errorf("sección = %v, se esperaba [c.com]", managed)
+	}
+}
+
+func TestHostsManagerCheckTamper(t *testing.T) {
+	manager := writeTempHosts(t, "127.0.0.1 localhost\n")
+	if event, err := manager.CheckTamper(); event != nil || err != nil {
+		t.Fatalf("sin conjunto aplicado: evento %+v, error %v", event, err)
+	}
+	if err := manager.Apply([]string{"a.com", "b.com"}); err != nil {
+		t.Fatalf("error aplicando: %v", err)
+	}
+	applied := readTempHosts(t, manager)
+	if event, err := manager.CheckTamper(); event != nil || err != nil {
+		t.Fatalf("sin cambios: evento %+v, error %v", event, err)
+	}
+
+	// Un cambio fuera de la sección no es manipulación.
+	itLine := "10.0.0.5 intranet\n"
+	if err := os.WriteFile(manager.Path(), []byte(itLine+applied), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if event, err := manager.CheckTamper(); event != nil || err != nil {
+		t.Fatalf("cambio fuera de la sección: evento %+v, error %v", event, err)
+	}
+
+	// Quitar una entrada de la sección se detecta y se repara.
+	tampered := strings.Replace(itLine+applied, "0.0.0.0 b.com\n", "", 1)
+	if err := os.WriteFile(manager.Path(), []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+	event, err := manager.CheckTamper()
+	if err != nil {
+		t.Fatalf("error verificando: %v", err)
+	}
+	if event == nil {
+		t.Fatal("no se detectó la manipulación")
+	}
+	if !reflect.DeepEqual(event.Expected, []string{"a.com", "b.com"}) || !reflect.DeepEqual(event.Found, []string{"a.com"}) || !event.Restored {
+		t.Errorf("evento = %+v", event)
+	}
+	if got := readTempHosts(t, manager); got != itLine+applied {
+		t.Errorf("contenido reparado = %q, se esperaba %q", got, itLine+applied)
+	}
+	if event, err := manager.CheckTamper(); event != nil || err != nil {
+		t.Fatalf("tras reparar: evento %+v, error %v", event, err)
+	}
+}
@@ -0,0 +1,4 @@
+package services
+
+// DefaultHostsPath es la ubicación del archivo hosts del sistema.
+const DefaultHostsPath = "/etc/hosts"
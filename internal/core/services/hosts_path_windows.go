@@ -0,0 +1,4 @@
+package services
+
+// DefaultHostsPath es la ubicación del archivo hosts del sistema.
+const DefaultHostsPath = `C:\Windows\System32\drivers\etc\hosts`
@@ -1,85 +1,123 @@
 package services
 
 import (
-	"bufio"
+	"context"
 	"fmt"
 	"log"
-	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 )
 
 //cambios
 
+// AddURLsToHostsFile agrega las URLs a la sección administrada del archivo
+// hosts. Cada entrada se normaliza y los comodines se expanden a los
+// subdominios configurados; las entradas inválidas se ignoran.
 func AddURLsToHostsFile(urls []string) error {
-	const hostsFilePath = "C:\\Windows\\System32\\drivers\\etc\\hosts"
-
-	file, err := os.OpenFile(hostsFilePath, os.O_RDWR|os.O_APPEND, 0644)
+	current, err := defaultHostsManager.Current()
 	if err != nil {
-		return fmt.Errorf("error al abrir el archivo hosts: %v", err)
-	}
-	defer file.Close()
-
-	existingLines := make(map[string]bool)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		existingLines[line] = true
+		return err
 	}
-
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error al leer el archivo hosts: %v", err)
+	existing := make(map[string]bool, len(current))
+	for _, host := range current {
+		existing[host] = true
 	}
 
-	for _, url := range urls {
-		entry := fmt.Sprintf("0.0.0.0 %s", url)
-		if !existingLines[entry] {
-			if _, err := file.WriteString(entry + "\n"); err != nil {
-				return fmt.Errorf("error al escribir en el archivo hosts: %v", err)
-			}
-			color.Green("URL añadida al archivo hosts: %s", entry)
-		} else {
-			color.Yellow("URL ya existe en el archivo hosts: %s", entry)
+	rules, _ := ParseURLRules(urls)
+	added := false
+	for _, host := range ExpandURLRules(rules, subdomainsFor(GetCurrentConfig())) {
+		if existing[host] {
+			continue
 		}
+		existing[host] = true
+		current = append(current, host)
+		added = true
+		color.Green("URL añadida al archivo hosts: %s", host)
 	}
-
-	return nil
+	if !added {
+		return nil
+	}
+	return defaultHostsManager.Apply(current)
 }
 
+// RemoveURLsFromHostsFile quita las URLs de la sección administrada del
+// archivo hosts. Las líneas fuera de la sección nunca se modifican.
 func RemoveURLsFromHostsFile(urls []string) error {
-	const hostsFilePath = "C:\\Windows\\System32\\drivers\\etc\\hosts"
-
-	file, err := os.ReadFile(hostsFilePath)
+	current, err := defaultHostsManager.Current()
 	if err != nil {
-		return fmt.Errorf("error al leer el archivo hosts: %v", err)
+		return err
 	}
+	rules, _ := ParseURLRules(urls)
 
-	lines := strings.Split(string(file), "\n")
-	var newLines []string
-
-	for _, line := range lines {
-		shouldKeep := true
-		for _, url := range urls {
-			if strings.Contains(line, url) {
-				shouldKeep = false
-				color.Red("URL eliminada del archivo hosts: %s", line)
-				break
-			}
-		}
-		if shouldKeep {
-			newLines = append(newLines, line)
+	var remaining []string
+	for _, host := range current {
+		if matchesAnyRule(rules, host) {
+			color.Red("URL eliminada del archivo hosts: %s", host)
+			continue
 		}
+		remaining = append(remaining, host)
 	}
+	if len(remaining) == len(current) {
+		return nil
+	}
+	return defaultHostsManager.Apply(remaining)
+}
 
-	output := strings.Join(newLines, "\n")
-	err = os.WriteFile(hostsFilePath, []byte(output), 0644)
-	if err != nil {
-		return fmt.Errorf("error al escribir el archivo hosts: %v", err)
+// matchesAnyRule indica si el host está cubierto por alguna de las reglas.
+func matchesAnyRule(rules []URLRule, host string) bool {
+	for _, rule := range rules {
+		if rule.MatchesHost(host) {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+// HostsTamperEvent describe una modificación externa de la sección
+// administrada del archivo hosts.
+type HostsTamperEvent struct {
+	Path       string    `json:"path"`
+	Expected   []string  `json:"expected"`
+	Found      []string  `json:"found"`
+	Restored   bool      `json:"restored"`
+	Error      string    `json:"error,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// WatchHostsFile revisa periódicamente el archivo hosts, restaura la sección
+// administrada si alguien la modificó y entrega un evento por cada manipulación.
+// El canal se cierra al cancelarse ctx.
+func WatchHostsFile(ctx context.Context, interval time.Duration) <-chan HostsTamperEvent {
+	events := make(chan HostsTamperEvent, 8)
+	go func() {
+		defer close(events)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			event, err := defaultHostsManager.CheckTamper()
+			if err != nil {
+				log.Printf("Error verificando el archivo hosts: %v\n", err)
+			}
+			if event == nil {
+				continue
+			}
+			color.Red("Manipulación del archivo hosts detectada. Esperado: %v, encontrado: %v, restaurado: %v",
+				event.Expected, event.Found, event.Restored)
+			select {
+			case events <- *event:
+			default:
+			}
+		}
+	}()
+	return events
 }
 
 func CloseChromeTabsWithURLs(urls []string) error {
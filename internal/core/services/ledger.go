@@ -0,0 +1,357 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// LedgerEntry es un proceso restringido por el agente, con lo necesario para
+// deshacer la restricción si el agente termina sin liberarlo.
+type LedgerEntry struct {
+	PID         uint32    `json:"pid"`
+	Name        string    `json:"name"`
+	StartTime   time.Time `json:"start_time"`
+	SessionID   uint32    `json:"session_id,omitempty"`
+	UID         uint32    `json:"uid,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	CommandLine string    `json:"cmdline,omitempty"`
+	Action      string    `json:"action"`
+	Relaunch    bool      `json:"relaunch,omitempty"`
+	Windows     []uint64  `json:"windows,omitempty"`
+	RecordedAt  time.Time `json:"recorded_at"`
+}
+
+// process retorna la identidad del proceso registrado.
+func (e LedgerEntry) process() ProcessInfo {
+	return ProcessInfo{Name: e.Name, ID: e.PID, StartTime: e.StartTime, SessionID: e.SessionID, UID: e.UID,
+		Path: e.Path, CommandLine: e.CommandLine}
+}
+
+type ledgerState struct {
+	Processes []LedgerEntry `json:"processes"`
+	HostsPath string        `json:"hosts_path,omitempty"`
+	Hosts     []string      `json:"hosts,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Ledger es el registro persistente de todo lo que el agente restringió:
+// procesos y entradas del archivo hosts. Se actualiza antes de cada acción,
+// de forma que si el agente se cierra de forma inesperada (o lo termina
+// runsb.bat / update.bat) el siguiente inicio pueda deshacerlo.
+//
+// Un *Ledger nil no registra nada.
+type Ledger struct {
+	path   string
+	mu     sync.Mutex
+	state  ledgerState
+	loaded bool
+}
+
+// DefaultLedgerPath retorna la ubicación del registro en la carpeta de
+// configuración del usuario.
+func DefaultLedgerPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ScrapeBlocker", "ledger.json")
+}
+
+// defaultLedger es el registro del agente. Se pasa a los monitores, al
+// archivo hosts y a los Enforcers; su contenido se lee en el primer uso.
+var defaultLedger = NewLedger(DefaultLedgerPath())
+
+// NewLedger crea un registro en la ruta indicada. Su contenido se carga la
+// primera vez que se consulta o modifica.
+func NewLedger(path string) *Ledger {
+	return &Ledger{path: path}
+}
+
+// loadLocked carga el contenido del archivo si aún no se cargó.
+func (l *Ledger) loadLocked() {
+	if l.loaded {
+		return
+	}
+	l.loaded = true
+	content, err := os.ReadFile(l.path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(content, &l.state); err != nil {
+		log.Printf("Registro de restricciones %s ilegible, se descarta: %v\n", l.path, err)
+		l.state = ledgerState{}
+	}
+}
+
+// Path retorna la ruta del registro.
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Entries retorna los procesos registrados.
+func (l *Ledger) Entries() []LedgerEntry {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadLocked()
+	return append([]LedgerEntry(nil), l.state.Processes...)
+}
+
+// Hosts retorna el archivo hosts y las entradas registradas.
+func (l *Ledger) Hosts() (string, []string) {
+	if l == nil {
+		return "", nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadLocked()
+	return l.state.HostsPath, append([]string(nil), l.state.Hosts...)
+}
+
+// RecordProcess registra que el proceso se va a restringir con la acción indicada.
+func (l *Ledger) RecordProcess(process ProcessInfo, options EnforcementOptions) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadLocked()
+	i := l.indexLocked(process.ID)
+	if i >= 0 && l.state.Processes[i].process().SameProcess(process) {
+		return nil
+	}
+	if i >= 0 {
+		// El PID se reutilizó: el proceso registrado ya no existe.
+		l.state.Processes = append(l.state.Processes[:i], l.state.Processes[i+1:]...)
+	}
+	l.state.Processes = append(l.state.Processes, LedgerEntry{
+		PID:         process.ID,
+		Name:        process.Name,
+		StartTime:   process.StartTime,
+		SessionID:   process.SessionID,
+		UID:         process.UID,
+		Path:        process.Path,
+		CommandLine: process.CommandLine,
+		Action:      options.Action,
+		Relaunch:    options.Relaunch,
+		RecordedAt:  time.Now(),
+	})
+	return l.saveLocked()
+}
+
+// RecordWindow registra una ventana del proceso que se va a ocultar,
+// minimizar o deshabilitar.
+func (l *Ledger) RecordWindow(pid uint32, window uint64) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadLocked()
+	i := l.indexLocked(pid)
+	if i < 0 {
+		return nil
+	}
+	for _, known := range l.state.Processes[i].Windows {
+		if known == window {
+			return nil
+		}
+	}
+	l.state.Processes[i].Windows = append(l.state.Processes[i].Windows, window)
+	return l.saveLocked()
+}
+
+// ForgetProcess quita el proceso del registro una vez liberado.
+func (l *Ledger) ForgetProcess(process ProcessInfo) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadLocked()
+	i := l.indexLocked(process.ID)
+	if i < 0 || !l.state.Processes[i].process().SameProcess(process) {
+		return nil
+	}
+	l.state.Processes = append(l.state.Processes[:i], l.state.Processes[i+1:]...)
+	return l.saveLocked()
+}
+
+// RecordHosts registra las entradas que se van a escribir en el archivo hosts.
+func (l *Ledger) RecordHosts(path string, hosts []string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loadLocked()
+	if len(hosts) == 0 && len(l.state.Hosts) == 0 {
+		return nil
+	}
+	l.state.HostsPath = path
+	l.state.Hosts = append([]string(nil), hosts...)
+	return l.saveLocked()
+}
+
+// Reset vacía el registro.
+func (l *Ledger) Reset() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loaded = true
+	l.state = ledgerState{}
+	return l.saveLocked()
+}
+
+// indexLocked retorna la entrada del PID. RecordProcess reemplaza la de un
+// proceso anterior con el mismo PID, así que hay a lo sumo una por PID.
+func (l *Ledger) indexLocked(pid uint32) int {
+	for i, entry := range l.state.Processes {
+		if entry.PID == pid {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *Ledger) saveLocked() error {
+	l.state.UpdatedAt = time.Now()
+	content, err := json.MarshalIndent(l.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("error creando la carpeta del registro: %v", err)
+	}
+	return writeFileAtomic(l.path, content)
+}
+
+// RecoverFromLedger deshace lo que quedó registrado de una ejecución anterior
+// que no terminó limpiamente: reanuda los procesos suspendidos, restaura sus
+// ventanas, vuelve a iniciar los terminados con relaunch y elimina las
+// entradas del archivo hosts. Debe ejecutarse antes de iniciar el monitor.
+//
+// En el modo multisesión los procesos registrados pertenecen a las sesiones
+// de los usuarios y no a la del agente, por lo que se buscan en todas las
+// sesiones interactivas. Si no se pudieron enumerar los procesos se conservan
+// las entradas para reintentarlo en el próximo inicio.
+func RecoverFromLedger(systemManager SystemManager, multiSession bool) error {
+	entries := defaultLedger.Entries()
+	hostsPath, hosts := defaultLedger.Hosts()
+	if len(entries) == 0 && len(hosts) == 0 {
+		return nil
+	}
+	log.Printf("Recuperando %d procesos y %d entradas de hosts de una ejecución anterior\n", len(entries), len(hosts))
+
+	var listErr error
+	if len(entries) > 0 {
+		appManager := NewApplicationManager(systemManager)
+		var running []ProcessInfo
+		if multiSession {
+			running, listErr = listApplicationsInAllSessions(systemManager, appManager)
+		} else {
+			running, listErr = appManager.ListApplicationsInCurrentSession()
+		}
+		if listErr == nil {
+			for _, entry := range entries {
+				if entry.Relaunch {
+					appManager.DescribeProcesses(running)
+					break
+				}
+			}
+			for _, entry := range entries {
+				recoverLedgerEntry(appManager, entry, running)
+			}
+		}
+	}
+
+	if len(hosts) > 0 {
+		if hostsPath == "" {
+			hostsPath = DefaultHostsPath
+		}
+		manager := defaultHostsManager
+		if hostsPath != manager.Path() {
+			manager = NewHostsManager(hostsPath, defaultLedger)
+		}
+		if err := manager.Clear(); err != nil {
+			return fmt.Errorf("error limpiando las entradas de hosts huérfanas: %v", err)
+		}
+		log.Printf("Entradas de hosts huérfanas eliminadas de %s\n", hostsPath)
+	}
+
+	if listErr != nil {
+		if err := defaultLedger.RecordHosts(hostsPath, nil); err != nil {
+			log.Printf("Error actualizando el registro de restricciones: %v\n", err)
+		}
+		return fmt.Errorf("error listando las aplicaciones para la recuperación, se reintentará en el próximo inicio: %v", listErr)
+	}
+	return defaultLedger.Reset()
+}
+
+// listApplicationsInAllSessions retorna los procesos de todas las sesiones
+// interactivas del equipo.
+func listApplicationsInAllSessions(systemManager SystemManager, appManager ApplicationManager) ([]ProcessInfo, error) {
+	if err := systemManager.EnableDebugPrivilege(); err != nil {
+		log.Printf("Error habilitando los privilegios para acceder a otras sesiones: %v\n", err)
+	}
+	sessions, err := systemManager.ListInteractiveSessions()
+	if err != nil {
+		return nil, err
+	}
+	var running []ProcessInfo
+	for _, session := range sessions {
+		processes, err := appManager.ListApplicationsInSession(session)
+		if err != nil {
+			return nil, fmt.Errorf("sesión %d del usuario %s: %v", session.ID, session.User, err)
+		}
+		running = append(running, processes...)
+	}
+	return running, nil
+}
+
+func recoverLedgerEntry(appManager ApplicationManager, entry LedgerEntry, running []ProcessInfo) {
+	var current *ProcessInfo
+	for i := range running {
+		if running[i].ID == entry.PID && (entry.StartTime.IsZero() || running[i].SameProcess(entry.process())) {
+			current = &running[i]
+			break
+		}
+	}
+
+	if entry.Action == domain.EnforcementTerminate || current == nil {
+		if !entry.Relaunch || current != nil || entry.Path == "" {
+			return
+		}
+		for _, process := range running {
+			if normalizePath(process.Path) == normalizePath(entry.Path) {
+				return
+			}
+		}
+		if err := NewTerminateEnforcer(appManager, true).Release(entry.process()); err != nil {
+			log.Printf("Error volviendo a iniciar %s: %v\n", entry.Name, err)
+		} else {
+			log.Printf("Proceso %s vuelto a iniciar.\n", entry.Name)
+		}
+		return
+	}
+
+	restoreWindows(entry.PID, entry.Windows)
+	if entry.Action == "" || entry.Action == domain.EnforcementSuspend {
+		if err := appManager.ResumeProcess(*current); err != nil {
+			log.Printf("Error reanudando el proceso huérfano %s (PID %d): %v\n", entry.Name, entry.PID, err)
+			return
+		}
+	}
+	log.Printf("Proceso huérfano %s (PID %d) liberado.\n", entry.Name, entry.PID)
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLedgerReplacesReusedPID(t *testing.T) {
+	ledger := newTestLedger(t)
+	old := cobisTable[5]
+	reused := old
+	reused.StartTime = old.StartTime.Add(time.Hour)
+
+	ledger.RecordProcess(old, EnforcementOptions{})
+	ledger.RecordProcess(old, EnforcementOptions{})
+	ledger.RecordProcess(reused, EnforcementOptions{})
+	entries := ledger.Entries()
+	if len(entries) != 1 || !entries[0].process().SameProcess(reused) {
+		t.Fatalf("entradas = %+v, se esperaba solo el proceso nuevo", entries)
+	}
+
+	// Olvidar el proceso anterior no quita el nuevo.
+	ledger.ForgetProcess(old)
+	if entries := ledger.Entries(); len(entries) != 1 {
+		t.Fatalf("se quitó la entrada del proceso nuevo: %+v", entries)
+	}
+
+	// Las entradas sobreviven a recargar el archivo.
+	reloaded := NewLedger(ledger.Path())
+	reloaded.ForgetProcess(reused)
+	if entries := reloaded.Entries(); len(entries) != 0 {
+		t.Fatalf("entradas tras olvidar = %+v", entries)
+	}
+}
+
+func TestNilLedgerRecordsNothing(t *testing.T) {
+	var ledger *Ledger
+	if err := ledger.RecordProcess(cobisTable[1], EnforcementOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := ledger.Entries(); entries != nil {
+		t.Fatalf("entradas = %+v", entries)
+	}
+}
@@ -1,199 +1,360 @@
 package services
 
 import (
+	"context"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/policy"
 )
 
-func MonitorProcesses(systemManager SystemManager, initialProcesses []string, initialUrls []string, user *domain.User) {
+// MonitorProcesses evalúa la política en ciclos hasta que se cancela ctx. Al
+// cancelarse libera todo lo restringido (procesos, bloqueo de URLs y pestañas)
+// antes de retornar.
+func MonitorProcesses(ctx context.Context, systemManager SystemManager, initialProcesses []string, initialUrls []string, user *domain.User) {
 	chromeService := NewChromeService("https://apps.mypurecloud.com")
-	appManager := NewWindowsApplicationManager(systemManager)
-
-	selectors := []string{
-		"participant call-participant text-center ember-view",
-		"sms-textarea message-input form-control",
-		"interaction-icon roster-email ember-view",
-	}
+	appManager := NewApplicationManager(systemManager)
+	callDetector := NewCallStateDetector(chromeService)
+	chromeEvents := chromeService.Subscribe()
+	processStarts := WatchProcessStarts(ctx, DefaultProcessWatchInterval)
+	blockers := &blockerHolder{}
+	enforcers := newEnforcerSet(appManager, defaultLedger)
+	registry := newEnforcementRegistry(defaultLedger)
 
+	var engine *policy.Engine
+	var enginePolicy *policy.RuleSet
+	var matcher *ProcessMatcher
+	var matcherSelectors []domain.ProcessSelector
+	var previousDecision policy.Decision
 	var previousMatchingProcesses []ProcessInfo
-	var previousShouldBlock bool
+	var urlState appliedURLState
+
+	defer enforcers.close()
+	defer releaseAll(chromeService, blockers, registry)
+
+	// Una configuración nueva se aplica en el próximo ciclo con el conjunto
+	// exacto de URLs y procesos; se adelanta para no esperar el intervalo.
+	unsubscribe := OnConfigChange(func(ConfigResponse) { wakeMonitor() })
+	defer unsubscribe()
+
+	for ctx.Err() == nil {
+		serveTabRestoreRequests(chromeService)
 
-	for {
 		// 1) Obtener la configuración actual (actualizada vía WS) desde el store.
 		cfg := GetCurrentConfig()
 
-		// Si la configuración actual está vacía, se usan los valores iniciales.
-		var processesToMonitor, urlsToBlock []string
-		if len(cfg.ProcessesToMonitor) == 0 {
-			processesToMonitor = initialProcesses
-		} else {
-			processesToMonitor = cfg.ProcessesToMonitor
+		// Los selectores se recompilan solo si cambiaron.
+		if selectors := processSelectorsFor(cfg, initialProcesses); matcher == nil || !equalSelectors(selectors, matcherSelectors) {
+			matcher = compileMonitorMatcher(selectors)
+			matcherSelectors = selectors
 		}
+
+		// Si la configuración actual está vacía, se usan los valores iniciales.
+		var urlsToBlock []string
 		if len(cfg.UrlsToBlock) == 0 {
 			urlsToBlock = initialUrls
 		} else {
 			urlsToBlock = cfg.UrlsToBlock
 		}
+		urlsToBlock = validURLEntries(urlsToBlock)
 
-		// 2) Si el usuario no está activo, desbloquear todo y continuar.
-		if !user.Active {
-			log.Println("El usuario no está activo. Desbloqueando todos los aplicativos.")
-			if err := RemoveURLsFromHostsFile(urlsToBlock); err != nil {
-				log.Printf("Error eliminando las URLs del archivo hosts: %v\n", err)
-			} else {
-				log.Println("Todas las URLs desbloqueadas correctamente.")
-			}
-
-			activeProcesses, err := appManager.ListApplicationsInCurrentSession()
+		// 2) Reconstruir el motor de políticas solo si la política cambió.
+		if engine == nil || cfg.Policy != enginePolicy {
+			var err error
+			engine, err = policy.NewEngine(cfg.Policy)
 			if err != nil {
-				log.Printf("Error listando las aplicaciones: %v\n", err)
-			} else {
-				matchingProcesses := appManager.Intersect(activeProcesses, convertProcessNamesToProcessInfo(processesToMonitor))
-				log.Printf("Procesos a desbloquear: %v\n", matchingProcesses)
-				for _, process := range matchingProcesses {
-					handles, err := appManager.GetProcessHandlesInCurrentSession(process.Name)
-					if err != nil {
-						log.Printf("Error obteniendo los manejadores del proceso %s: %v\n", process.Name, err)
-						continue
-					}
-					for _, handle := range handles {
-						log.Printf("Intentando reanudar el proceso %s con el manejador %v\n", process.Name, handle)
-						if err := appManager.ResumeProcess(handle); err != nil {
-							log.Printf("Error reanudando el proceso %s: %v\n", process.Name, err)
-						} else {
-							log.Printf("Proceso %s reanudado.\n", process.Name)
-						}
-					}
-				}
+				log.Printf("Política inválida, se usa la política por defecto: %v\n", err)
 			}
-			previousMatchingProcesses = nil
-			previousShouldBlock = false
-
-			time.Sleep(2 * time.Second)
-			continue
+			enginePolicy = cfg.Policy
 		}
 
-		shouldBlock := true
-		htmlContent, err := chromeService.GetFullPageHTML()
+		// 3) Recolectar los hechos del ciclo.
+		activeProcesses, err := appManager.ListApplicationsInCurrentSession()
 		if err != nil {
-			log.Printf("Error obteniendo el HTML de la página: %v\n", err)
-			shouldBlock = true
-		} else {
-			for _, selector := range selectors {
-				if strings.Contains(htmlContent, selector) {
-					shouldBlock = false
-					break
-				}
-			}
+			recordError("Error listando las aplicaciones: %v\n", err)
 		}
+		matchingProcesses := matchProcesses(appManager, matcher, activeProcesses)
+		log.Printf("Procesos coincidentes: %v\n", matchingProcesses)
 
-		if shouldBlock {
-			if err := chromeService.BlockURLsInHosts(urlsToBlock); err != nil {
-				log.Printf("Error bloqueando las URLs en el archivo hosts: %v\n", err)
-			} else {
-				log.Println("URLs bloqueadas exitosamente en el archivo hosts.")
+		facts := policy.Facts{
+			UserActive:       user.Active,
+			Now:              time.Now(),
+			RunningProcesses: processNames(activeProcesses),
+			ServerOnline:     GetConnectionState() == ConnectionConnected,
+			Override:         overrideFor(user.Name),
+		}
+		if user.Active {
+			if err := callDetector.Ensure(detectionRulesFor(cfg)); err != nil {
+				recordError("Error instalando el detector de llamadas: %v\n", err)
 			}
+			facts.CallInProgress = callDetector.InCall()
 		} else {
-			if err := RemoveURLsFromHostsFile(urlsToBlock); err != nil {
-				log.Printf("Error eliminando las URLs del archivo hosts: %v\n", err)
-			} else {
-				log.Println("URLs eliminadas exitosamente del archivo hosts.")
-			}
+			log.Println("El usuario no está activo.")
+		}
 
-			if err := chromeService.NavigateBackToPreviousURLs(); err != nil {
-				log.Printf("Error navegando de regreso a las URLs anteriores: %v\n", err)
-			} else {
-				log.Println("Navegación de regreso a las URLs anteriores completada exitosamente.")
+		// 4) Consultar al motor y aplicar solo los cambios.
+		decision := engine.Evaluate(facts, policy.Targets{
+			Processes: processNames(append(matchingProcesses, registryProcesses(registry)...)),
+			URLs:      urlsToBlock,
+		})
+		changes := decision.Diff(previousDecision)
+
+		// Si cambiaron las instancias en ejecución se reaplica el veredicto de
+		// todos los procesos para cubrir las instancias nuevas.
+		if !appManager.EqualProcessSlices(matchingProcesses, previousMatchingProcesses) {
+			for name, verdict := range decision.Processes {
+				changes.Processes[name] = verdict
 			}
 		}
 
-		activeProcesses, err := appManager.ListApplicationsInCurrentSession()
-		if err != nil {
-			log.Printf("Error listando las aplicaciones: %v\n", err)
-			time.Sleep(2 * time.Second)
-			continue
+		applyURLVerdicts(chromeService, blockers.get(cfg), &urlState, decision, changes)
+		failed := applyProcessVerdicts(appManager, enforcers, registry, changes, matchingProcesses, activeProcesses, matcher)
+
+		// Cerrar los manejadores de procesos que terminaron o dejaron de coincidir.
+		appManager.RetainHandles(matchingProcesses)
+		updateAgentStatus(func(status *AgentStatus) {
+			status.Handles = appManager.HandleStats()
+			status.UserActive = facts.UserActive
+			status.CallInProgress = facts.CallInProgress
+			status.CDPConnected = chromeService.Connected()
+			status.BlockedURLs = policy.Filter(decision.URLs, policy.VerdictBlockURL)
+			status.Restricted = registry.restricted()
+			status.Overrides = ActiveOverrides()
+		})
+
+		previousDecision = decision
+		previousMatchingProcesses = matchingProcesses
+		if failed {
+			// Se fuerza a reaplicar los veredictos para reintentar lo que falló.
+			previousMatchingProcesses = nil
 		}
 
-		matchingProcesses := appManager.Intersect(activeProcesses, convertProcessNamesToProcessInfo(processesToMonitor))
-		log.Printf("Procesos coincidentes: %v\n", matchingProcesses)
+		waitForNextCycle(ctx, chromeEvents, callDetector.Events(), processStarts, matcher, 2*time.Second)
+	}
+}
 
-		disappearedProcesses := difference(previousMatchingProcesses, matchingProcesses)
-		if len(disappearedProcesses) > 0 {
-			log.Printf("Procesos que ya no están en la config: %v\n", disappearedProcesses)
-			for _, process := range disappearedProcesses {
-				handles, err := appManager.GetProcessHandlesInCurrentSession(process.Name)
-				if err != nil {
-					log.Printf("Error obteniendo los manejadores del proceso %s: %v\n", process.Name, err)
-					continue
-				}
-				for _, handle := range handles {
-					log.Printf("Reanudando proceso %s (eliminado de la config) con manejador %v\n", process.Name, handle)
-					if err := appManager.ResumeProcess(handle); err != nil {
-						log.Printf("Error reanudando el proceso %s: %v\n", process.Name, err)
-					} else {
-						log.Printf("Proceso %s reanudado (ya no está en la config).\n", process.Name)
-					}
+// waitForNextCycle espera hasta el siguiente ciclo del monitor, que ocurre al
+// vencer el intervalo, de inmediato cuando cambia el estado de llamada, inicia
+// un proceso que podría estar monitoreado o llega una orden remota, o cuando
+// Chrome notifica un cambio de pestañas. Las ráfagas de eventos de Chrome se agrupan para no
+// reevaluar por cada uno. Retorna de inmediato al cancelarse ctx.
+func waitForNextCycle(ctx context.Context, events <-chan CDPEvent, callEvents <-chan CallEvent, processStarts <-chan ProcessStartEvent, matcher *ProcessMatcher, interval time.Duration) {
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-callEvents:
+			return
+		case <-monitorWakeups:
+			return
+		case start := <-processStarts:
+			if matcher.MayMatch(start.Process) {
+				log.Printf("Proceso monitoreado iniciado: %s (PID %d)\n", start.Process.Name, start.Process.ID)
+				return
+			}
+		case event := <-events:
+			if !triggersCycle(event.Method) {
+				continue
+			}
+			debounce := time.NewTimer(250 * time.Millisecond)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-events:
+				case <-debounce.C:
+					return
 				}
 			}
 		}
+	}
+}
+
+// triggersCycle indica si el evento CDP justifica reevaluar de inmediato.
+func triggersCycle(method string) bool {
+	switch method {
+	case "Target.targetCreated", "Target.targetInfoChanged", "Target.targetDestroyed", "DOM.documentUpdated":
+		return true
+	}
+	return false
+}
+
+// appliedURLState recuerda en qué backend se aplicó por última vez el
+// conjunto bloqueado y si se aplicó correctamente.
+type appliedURLState struct {
+	blocker Blocker
+	ok      bool
+}
+
+// matchProcesses describe solo los procesos que podrían coincidir por su
+// nombre y retorna los que coinciden con los selectores.
+func matchProcesses(appManager ApplicationManager, matcher *ProcessMatcher, processes []ProcessInfo) []ProcessInfo {
+	candidates := matcher.Candidates(processes)
+	appManager.DescribeProcesses(candidates)
+	return matcher.Match(candidates)
+}
+
+// applyURLVerdicts aplica los veredictos de URL. El backend recibe el
+// conjunto bloqueado completo cuando algún veredicto cambia, cuando se
+// recreó el backend o cuando el intento anterior falló; la redirección de
+// pestañas se reaplica en cada ciclo mientras dure el bloqueo.
+func applyURLVerdicts(chromeService ChromeService, blocker Blocker, state *appliedURLState, decision, changes policy.Decision) {
+	toBlock := policy.Filter(decision.URLs, policy.VerdictBlockURL)
 
-		if !appManager.EqualProcessSlices(matchingProcesses, previousMatchingProcesses) || shouldBlock != previousShouldBlock {
-			for _, process := range matchingProcesses {
-				handles, err := appManager.GetProcessHandlesInCurrentSession(process.Name)
-				if err != nil {
-					log.Printf("Error obteniendo los manejadores del proceso %s: %v\n", process.Name, err)
-					continue
+	if len(changes.URLs) > 0 || state.blocker != blocker || !state.ok {
+		rules, _ := ParseURLRules(toBlock)
+		err := blocker.Apply(rules)
+		state.blocker = blocker
+		state.ok = err == nil
+		if err != nil {
+			recordError("Error aplicando el bloqueo de URLs (%s): %v\n", blocker.Name(), err)
+		} else {
+			log.Printf("URLs bloqueadas (%s): %v\n", blocker.Name(), toBlock)
+		}
+	}
+
+	if len(toBlock) > 0 {
+		if err := chromeService.RedirectBlockedTabs(toBlock); err != nil {
+			recordError("Error redirigiendo las pestañas bloqueadas: %v\n", err)
+		}
+	}
+
+	if toUnblock := policy.Filter(changes.URLs, policy.VerdictUnblockURL); len(toUnblock) > 0 {
+		if err := chromeService.NavigateBackToPreviousURLs(); err != nil {
+			recordError("Error navegando de regreso a las URLs anteriores: %v\n", err)
+		} else {
+			log.Println("Navegación de regreso a las URLs anteriores completada exitosamente.")
+		}
+	}
+}
+
+// applyProcessVerdicts restringe o libera los procesos cuyo veredicto cambió.
+// Se restringen las instancias que coinciden con los selectores, con la
+// acción que indica su selector y, si lo pide, junto con todo su árbol de
+// descendientes tomado de la tabla de procesos del ciclo. Al liberar se
+// deshace lo registrado; si no hay registro se reanudan todas las instancias
+// del nombre en la tabla para no dejar ninguna suspendida. Retorna true si
+// algún proceso no se pudo restringir; ese proceso sale del registro y el
+// monitor debe reaplicar los veredictos en el próximo ciclo.
+func applyProcessVerdicts(appManager ApplicationManager, enforcers *enforcerSet, registry *enforcementRegistry, changes policy.Decision, matching, table []ProcessInfo, matcher *ProcessMatcher) (failed bool) {
+	for name, verdict := range changes.Processes {
+		switch verdict {
+		case policy.VerdictSuspend:
+			for _, process := range filterByName(matching, name) {
+				options := enforcers.effective(matcher.OptionsFor(process))
+				enforcer := enforcers.get(options)
+				group := []ProcessInfo{process}
+				if options.SuspendTree {
+					descendants := descendantsOf(process, table)
+					appManager.DescribeProcesses(descendants)
+					group = append(group, descendants...)
+				}
+				added, replaced := registry.record(process, enforcer, group)
+				if replaced != nil {
+					releaseGroups(registry, []enforcedGroup{*replaced})
 				}
-				for _, handle := range handles {
-					if shouldBlock {
-						log.Printf("Intentando suspender el proceso %s con el manejador %v\n", process.Name, handle)
-						if err := appManager.SuspendProcess(handle); err != nil {
-							log.Printf("Error suspendiendo el proceso %s: %v\n", process.Name, err)
-						} else {
-							log.Printf("Proceso %s suspendido.\n", process.Name)
+				for _, member := range added {
+					if err := registry.ledger.RecordProcess(member, options); err != nil {
+						recordError("Error registrando el proceso %s: %v\n", member.Name, err)
+					}
+					log.Printf("Intentando restringir (%s) el proceso %s con PID %d\n", enforcer.Name(), member.Name, member.ID)
+					if err := enforcer.Enforce(member); err != nil {
+						recordError("Error restringiendo el proceso %s: %v\n", member.Name, err)
+						failed = true
+						registry.drop(process, member)
+						if !registry.contains(member) {
+							if err := registry.ledger.ForgetProcess(member); err != nil {
+								recordError("Error actualizando el registro del proceso %s: %v\n", member.Name, err)
+							}
 						}
-					} else {
-						log.Printf("Intentando reanudar el proceso %s con el manejador %v\n", process.Name, handle)
-						if err := appManager.ResumeProcess(handle); err != nil {
-							log.Printf("Error reanudando el proceso %s: %v\n", process.Name, err)
-						} else {
-							log.Printf("Proceso %s reanudado.\n", process.Name)
+						if member.SameProcess(process) {
+							// Sin la raíz no se restringe su árbol; se reintenta completo.
+							break
 						}
+						continue
 					}
+					log.Printf("Proceso %s restringido.\n", member.Name)
 				}
 			}
+		case policy.VerdictResume:
+			groups := registry.takeByName(name)
+			if len(groups) == 0 {
+				for _, process := range filterByName(table, name) {
+					// Los que siguen restringidos como parte de otro grupo no se tocan.
+					if appManager.State(process.ID).Tracked {
+						continue
+					}
+					groups = append(groups, enforcedGroup{Enforcer: enforcers.get(EnforcementOptions{}), Members: []ProcessInfo{process}})
+				}
+			}
+			releaseGroups(registry, groups)
 		}
+	}
+	return failed
+}
 
-		previousMatchingProcesses = matchingProcesses
-		previousShouldBlock = shouldBlock
-
-		time.Sleep(2 * time.Second)
+// releaseGroups libera cada grupo en orden inverso y quita del registro
+// persistente los procesos que ya no pertenecen a ningún otro grupo.
+func releaseGroups(registry *enforcementRegistry, groups []enforcedGroup) {
+	for _, group := range groups {
+		for _, member := range group.releaseOrder() {
+			log.Printf("Intentando liberar (%s) el proceso %s con PID %d\n", group.Enforcer.Name(), member.Name, member.ID)
+			if err := group.Enforcer.Release(member); err != nil {
+				recordError("Error liberando el proceso %s: %v\n", member.Name, err)
+			} else {
+				log.Printf("Proceso %s liberado.\n", member.Name)
+			}
+		}
+		for _, member := range group.Members {
+			if registry.contains(member) {
+				continue
+			}
+			if err := registry.ledger.ForgetProcess(member); err != nil {
+				recordError("Error actualizando el registro del proceso %s: %v\n", member.Name, err)
+			}
+		}
 	}
 }
 
-// difference retorna los procesos que estaban en oldList y ya no aparecen en newList.
-func difference(oldList, newList []ProcessInfo) []ProcessInfo {
-	newMap := make(map[string]bool)
-	for _, proc := range newList {
-		newMap[proc.Name] = true
+// releaseAll deshace todas las restricciones vigentes al detener el monitor:
+// libera los procesos, limpia el backend de bloqueo y devuelve las pestañas
+// redirigidas a su URL anterior.
+func releaseAll(chromeService ChromeService, blockers *blockerHolder, registry *enforcementRegistry) {
+	log.Println("Deteniendo el monitor y liberando todos los bloqueos...")
+	releaseGroups(registry, registry.takeAll())
+	blockers.close()
+	if err := chromeService.NavigateBackToPreviousURLs(); err != nil {
+		recordError("Error navegando de regreso a las URLs anteriores: %v\n", err)
 	}
+	chromeService.Close()
+	log.Println("Monitor detenido.")
+}
 
-	var diff []ProcessInfo
-	for _, proc := range oldList {
-		if !newMap[proc.Name] {
-			diff = append(diff, proc)
-		}
+// registryProcesses retorna las raíces restringidas como ProcessInfo solo
+// con el nombre, para sumarlas a los objetivos de la política.
+func registryProcesses(registry *enforcementRegistry) []ProcessInfo {
+	var processes []ProcessInfo
+	for _, name := range registry.names() {
+		processes = append(processes, ProcessInfo{Name: name})
 	}
-	return diff
+	return processes
 }
 
-func convertProcessNamesToProcessInfo(processNames []string) []ProcessInfo {
-	var processInfos []ProcessInfo
-	for _, name := range processNames {
-		processInfos = append(processInfos, ProcessInfo{Name: name})
+// processNames retorna los nombres de los procesos sin duplicados.
+func processNames(processes []ProcessInfo) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, process := range processes {
+		if !seen[process.Name] {
+			seen[process.Name] = true
+			names = append(names, process.Name)
+		}
 	}
-	return processInfos
+	return names
 }
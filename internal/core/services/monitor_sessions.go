@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/policy"
+)
+
+// sessionMonitor es el estado del monitor para una sesión interactiva.
+type sessionMonitor struct {
+	session          Session
+	registry         *enforcementRegistry
+	previousDecision policy.Decision
+	previousMatching []ProcessInfo
+	warnedUnknown    bool
+	warnedNoCall     bool
+}
+
+// MonitorSessions es el modo multisesión para servidores de terminales
+// (Citrix/RDS): un único agente con privilegios enumera las sesiones
+// interactivas y evalúa la política para cada una con el estado que el
+// servidor informó de su usuario, restringiendo solo los procesos de esa
+// sesión. Los usuarios de los que el servidor no informó estado se
+// consideran inactivos.
+//
+// La llamada en curso no se puede detectar en el Chrome de cada sesión, por
+// lo que se toma del in_call que informa el servidor en user_state. Si la
+// política depende de la llamada y el servidor no informó in_call del
+// usuario, su sesión no se restringe (salvo orden remota) en lugar de
+// suspenderlo indefinidamente.
+//
+// El bloqueo de URLs (hosts, DNS o proxy) es global al equipo, por lo que en
+// este modo solo se aplican los veredictos de procesos. Al cancelarse ctx
+// libera todo lo restringido en todas las sesiones antes de retornar.
+func MonitorSessions(ctx context.Context, systemManager SystemManager, initialProcesses []string) {
+	if err := systemManager.EnableDebugPrivilege(); err != nil {
+		recordError("Error habilitando los privilegios para acceder a otras sesiones: %v\n", err)
+	}
+
+	appManager := NewApplicationManager(systemManager)
+	processStarts := WatchProcessStarts(ctx, DefaultProcessWatchInterval)
+	enforcers := newSessionEnforcerSet(appManager, defaultLedger)
+	monitors := make(map[string]*sessionMonitor)
+
+	var engine *policy.Engine
+	var enginePolicy *policy.RuleSet
+	var matcher *ProcessMatcher
+	var matcherSelectors []domain.ProcessSelector
+
+	defer enforcers.close()
+	defer releaseSessions(monitors)
+
+	for ctx.Err() == nil {
+		serveTabRestoreRequests(nil)
+
+		cfg := GetCurrentConfig()
+		// Los selectores se recompilan solo si cambiaron.
+		if selectors := processSelectorsFor(cfg, initialProcesses); matcher == nil || !equalSelectors(selectors, matcherSelectors) {
+			matcher = compileMonitorMatcher(selectors)
+			matcherSelectors = selectors
+		}
+
+		if engine == nil || cfg.Policy != enginePolicy {
+			var err error
+			engine, err = policy.NewEngine(cfg.Policy)
+			if err != nil {
+				log.Printf("Política inválida, se usa la política por defecto: %v\n", err)
+			}
+			enginePolicy = cfg.Policy
+		}
+
+		// Si no se pudo enumerar se conserva el estado de todas las sesiones
+		// hasta el siguiente ciclo en lugar de darlas por cerradas.
+		sessions, err := systemManager.ListInteractiveSessions()
+		if err != nil {
+			recordError("Error enumerando las sesiones interactivas: %v\n", err)
+			waitForNextCycle(ctx, nil, nil, processStarts, matcher, 2*time.Second)
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var retained []ProcessInfo
+		var statuses []SessionStatus
+		var restricted []RestrictedProcess
+		for _, session := range sessions {
+			key := session.Key()
+			seen[key] = true
+			monitor, ok := monitors[key]
+			if !ok {
+				log.Printf("Nueva sesión %d del usuario %s\n", session.ID, session.User)
+				monitor = &sessionMonitor{session: session, registry: newEnforcementRegistry(defaultLedger)}
+				monitors[key] = monitor
+			}
+
+			matching, status := monitor.cycle(engine, appManager, enforcers, matcher)
+			retained = append(retained, matching...)
+			statuses = append(statuses, status)
+			restricted = append(restricted, monitor.registry.restricted()...)
+		}
+
+		// Las sesiones que se cerraron se liberan; sus procesos normalmente ya
+		// terminaron, pero así se limpian el registro y el ledger.
+		for key, monitor := range monitors {
+			if seen[key] {
+				continue
+			}
+			log.Printf("Sesión %d del usuario %s cerrada\n", monitor.session.ID, monitor.session.User)
+			releaseGroups(monitor.registry, monitor.registry.takeAll())
+			delete(monitors, key)
+		}
+
+		appManager.RetainHandles(retained)
+		updateAgentStatus(func(status *AgentStatus) {
+			status.Handles = appManager.HandleStats()
+			status.Sessions = statuses
+			status.Restricted = restricted
+			status.Overrides = ActiveOverrides()
+		})
+
+		waitForNextCycle(ctx, nil, nil, processStarts, matcher, 2*time.Second)
+	}
+}
+
+// cycle evalúa la política para la sesión y aplica los cambios. Retorna los
+// procesos coincidentes y el resumen de la sesión.
+func (m *sessionMonitor) cycle(engine *policy.Engine, appManager ApplicationManager, enforcers *enforcerSet, matcher *ProcessMatcher) ([]ProcessInfo, SessionStatus) {
+	activeProcesses, err := appManager.ListApplicationsInSession(m.session)
+	if err != nil {
+		recordError("Error listando las aplicaciones de la sesión %d: %v\n", m.session.ID, err)
+	}
+	matching := matchProcesses(appManager, matcher, activeProcesses)
+
+	user, known := GetUserState(m.session.User)
+	if !known && !m.warnedUnknown {
+		log.Printf("Sin estado del usuario %s, se considera inactivo.\n", m.session.User)
+	}
+	m.warnedUnknown = !known
+
+	callKnown := known && user.InCall != nil
+	facts := policy.Facts{
+		CallInProgress:   callKnown && *user.InCall,
+		UserActive:       known && user.Active,
+		Now:              time.Now(),
+		RunningProcesses: processNames(activeProcesses),
+		ServerOnline:     GetConnectionState() == ConnectionConnected,
+		Override:         overrideFor(m.session.User),
+	}
+	status := SessionStatus{Session: m.session, Active: facts.UserActive, InCall: facts.CallInProgress}
+
+	if !callKnown && facts.Override == "" && engine.Uses(policy.ConditionCallInProgress) {
+		if !m.warnedNoCall {
+			recordError("Sin estado de llamada del usuario %s y la política depende de la llamada; no se restringe su sesión.\n", m.session.User)
+		}
+		m.warnedNoCall = true
+		releaseGroups(m.registry, m.registry.takeAll())
+		m.previousDecision = policy.Decision{}
+		m.previousMatching = nil
+		return matching, status
+	}
+	m.warnedNoCall = false
+
+	decision := engine.Evaluate(facts, policy.Targets{
+		Processes: processNames(append(matching, registryProcesses(m.registry)...)),
+	})
+	changes := decision.Diff(m.previousDecision)
+	if !appManager.EqualProcessSlices(matching, m.previousMatching) {
+		for name, verdict := range decision.Processes {
+			changes.Processes[name] = verdict
+		}
+	}
+
+	failed := applyProcessVerdicts(appManager, enforcers, m.registry, changes, matching, activeProcesses, matcher)
+
+	m.previousDecision = decision
+	m.previousMatching = matching
+	if failed {
+		// Se fuerza a reaplicar los veredictos para reintentar lo que falló.
+		m.previousMatching = nil
+	}
+
+	status.Restricted = m.registry.names()
+	return matching, status
+}
+
+// releaseSessions libera lo restringido en todas las sesiones al detener el
+// monitor multisesión.
+func releaseSessions(monitors map[string]*sessionMonitor) {
+	log.Println("Deteniendo el monitor multisesión y liberando todos los bloqueos...")
+	for _, monitor := range monitors {
+		releaseGroups(monitor.registry, monitor.registry.takeAll())
+	}
+	log.Println("Monitor multisesión detenido.")
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/windows"
+)
+
+// processHandleAccess es el acceso con el que se abren los manejadores
+// cacheados: suspender/reanudar, terminar, consultar y esperar su salida. Si
+// el proceso no permite terminarlo se abre sin ese permiso.
+const (
+	processHandleAccess = windows.PROCESS_SUSPEND_RESUME | windows.PROCESS_TERMINATE |
+		windows.PROCESS_QUERY_LIMITED_INFORMATION | windows.SYNCHRONIZE
+	processHandleFallbackAccess = windows.PROCESS_SUSPEND_RESUME |
+		windows.PROCESS_QUERY_LIMITED_INFORMATION | windows.SYNCHRONIZE
+)
+
+// handleKey identifica una instancia de proceso: el PID solo no basta porque
+// Windows lo reutiliza.
+type handleKey struct {
+	pid     uint32
+	created int64
+}
+
+func handleKeyOf(process ProcessInfo) handleKey {
+	return handleKey{pid: process.ID, created: process.StartTime.UnixNano()}
+}
+
+// processHandleCache mantiene abierto un manejador por instancia de proceso
+// restringida, en lugar de abrir uno nuevo en cada ciclo del monitor. Los
+// manejadores se cierran cuando el proceso termina o deja de interesar.
+type processHandleCache struct {
+	mu      sync.Mutex
+	handles map[handleKey]windows.Handle
+	stats   HandleStats
+}
+
+func newProcessHandleCache() *processHandleCache {
+	return &processHandleCache{handles: make(map[handleKey]windows.Handle)}
+}
+
+// get retorna el manejador de la instancia, abriéndolo si hace falta. Si el
+// proceso ya terminó el manejador se cierra y se retorna un error.
+func (c *processHandleCache) get(process ProcessInfo) (windows.Handle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := handleKeyOf(process)
+	if handle, ok := c.handles[key]; ok {
+		if !processExited(handle) {
+			c.stats.Reused++
+			return handle, nil
+		}
+		c.closeLocked(key)
+		return 0, fmt.Errorf("process %d (%s) has exited", process.ID, process.Name)
+	}
+
+	handle, err := windows.OpenProcess(processHandleAccess, false, process.ID)
+	if err != nil {
+		handle, err = windows.OpenProcess(processHandleFallbackAccess, false, process.ID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error opening process %d: %v", process.ID, err)
+	}
+	startTime, err := processStartTime(handle)
+	if err == nil && !startTime.Equal(process.StartTime) {
+		windows.CloseHandle(handle)
+		return 0, fmt.Errorf("process %d is no longer %s (PID reused)", process.ID, process.Name)
+	}
+	c.handles[key] = handle
+	c.stats.Opened++
+	return handle, nil
+}
+
+// evict cierra el manejador de la instancia, si está abierto.
+func (c *processHandleCache) evict(process ProcessInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked(handleKeyOf(process))
+}
+
+// retain cierra los manejadores de los procesos que terminaron y de los que no
+// están en keep ni cumplen keepPID (p. ej. los que siguen suspendidos).
+func (c *processHandleCache) retain(keep []ProcessInfo, keepPID func(uint32) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	wanted := make(map[handleKey]bool, len(keep))
+	for _, process := range keep {
+		wanted[handleKeyOf(process)] = true
+	}
+	for key, handle := range c.handles {
+		if processExited(handle) || (!wanted[key] && !keepPID(key.pid)) {
+			c.closeLocked(key)
+		}
+	}
+}
+
+func (c *processHandleCache) closeLocked(key handleKey) {
+	handle, ok := c.handles[key]
+	if !ok {
+		return
+	}
+	windows.CloseHandle(handle)
+	delete(c.handles, key)
+	c.stats.Closed++
+}
+
+func (c *processHandleCache) snapshot() HandleStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Open = len(c.handles)
+	return stats
+}
+
+// processExited indica si el proceso del manejador ya terminó.
+func processExited(handle windows.Handle) bool {
+	event, err := windows.WaitForSingleObject(handle, 0)
+	return err == nil && event == windows.WAIT_OBJECT_0
+}
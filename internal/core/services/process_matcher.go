@@ -0,0 +1,280 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// SelectorWarning es un problema detectado en un selector de procesos. Los
+// selectores rechazados no se usan; los demás avisos señalan probables
+// errores de tipeo (p. ej. "cxc,exe") que harían que nunca coincidan.
+type SelectorWarning struct {
+	Selector domain.ProcessSelector `json:"selector"`
+	Reason   string                 `json:"reason"`
+	Rejected bool                   `json:"rejected"`
+}
+
+// ProcessMatcher selecciona, de la tabla de procesos, los que coinciden con
+// alguno de los selectores configurados.
+type ProcessMatcher struct {
+	selectors []compiledSelector
+}
+
+type compiledSelector struct {
+	name        string
+	glob        bool
+	pattern     *regexp.Regexp
+	pathPrefix  string
+	commandLine string
+	parent      string
+	publisher   string
+	options     EnforcementOptions
+}
+
+// processSelectorsFor combina los nombres de "processes" (o los iniciales si
+// la configuración no trae ninguno) con los selectores de "process_selectors".
+func processSelectorsFor(cfg ConfigResponse, initialProcesses []string) []domain.ProcessSelector {
+	names := cfg.ProcessesToMonitor
+	if len(names) == 0 {
+		names = initialProcesses
+	}
+	selectors := make([]domain.ProcessSelector, 0, len(names)+len(cfg.ProcessSelectors))
+	for _, name := range names {
+		selectors = append(selectors, domain.ProcessSelector{Name: name})
+	}
+	return append(selectors, cfg.ProcessSelectors...)
+}
+
+// equalSelectors indica si a y b tienen los mismos selectores en el mismo
+// orden; el orden importa porque el primero que coincide define la acción.
+func equalSelectors(a, b []domain.ProcessSelector) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// compileMonitorMatcher compila los selectores del monitor y deja en el
+// registro los descartados, que no se aplican hasta que se corrijan.
+func compileMonitorMatcher(selectors []domain.ProcessSelector) *ProcessMatcher {
+	matcher, warnings := NewProcessMatcher(selectors)
+	for _, w := range warnings {
+		if w.Rejected {
+			recordError("Selector de procesos %+v descartado: %s\n", w.Selector, w.Reason)
+			continue
+		}
+		log.Printf("Selector de procesos %+v: %s\n", w.Selector, w.Reason)
+	}
+	return matcher
+}
+
+// NewProcessMatcher compila los selectores. Los inválidos se descartan y se
+// informan junto con los avisos.
+func NewProcessMatcher(selectors []domain.ProcessSelector) (*ProcessMatcher, []SelectorWarning) {
+	matcher := &ProcessMatcher{}
+	var warnings []SelectorWarning
+	for _, selector := range selectors {
+		compiled, notes, err := compileSelector(selector)
+		for _, note := range notes {
+			warnings = append(warnings, SelectorWarning{Selector: selector, Reason: note})
+		}
+		if err != nil {
+			warnings = append(warnings, SelectorWarning{Selector: selector, Reason: err.Error(), Rejected: true})
+			continue
+		}
+		matcher.selectors = append(matcher.selectors, compiled)
+	}
+	return matcher, warnings
+}
+
+func compileSelector(selector domain.ProcessSelector) (compiledSelector, []string, error) {
+	var notes []string
+	compiled := compiledSelector{
+		name:        strings.ToLower(strings.TrimSpace(selector.Name)),
+		pathPrefix:  normalizePath(strings.TrimSpace(selector.PathPrefix)),
+		commandLine: strings.ToLower(selector.CommandLine),
+		parent:      strings.ToLower(strings.TrimSpace(selector.Parent)),
+		publisher:   strings.ToLower(strings.TrimSpace(selector.Publisher)),
+		options: EnforcementOptions{
+			Action:      selector.Action,
+			Relaunch:    selector.Relaunch,
+			SuspendTree: selector.SuspendTree,
+		},
+	}
+	if compiled.options.Action == "" {
+		compiled.options.Action = domain.EnforcementSuspend
+	}
+
+	if compiled.name == "" && selector.Pattern == "" && compiled.pathPrefix == "" &&
+		compiled.commandLine == "" && compiled.parent == "" && compiled.publisher == "" {
+		return compiled, notes, fmt.Errorf("el selector no indica ningún criterio")
+	}
+
+	switch compiled.options.Action {
+	case domain.EnforcementSuspend, domain.EnforcementMinimize, domain.EnforcementHide,
+		domain.EnforcementTerminate, domain.EnforcementOverlay:
+	default:
+		return compiled, notes, fmt.Errorf("la acción %q es desconocida", selector.Action)
+	}
+	if selector.Relaunch && compiled.options.Action != domain.EnforcementTerminate {
+		notes = append(notes, fmt.Sprintf("relaunch solo aplica a la acción %q", domain.EnforcementTerminate))
+	}
+
+	if compiled.name != "" {
+		if compiled.name != strings.ToLower(selector.Name) {
+			notes = append(notes, fmt.Sprintf("el nombre %q tiene espacios al inicio o al final", selector.Name))
+		}
+		if strings.Contains(compiled.name, ",") {
+			notes = append(notes, fmt.Sprintf("el nombre %q contiene una coma; ¿quiso decir %q?",
+				selector.Name, strings.ReplaceAll(compiled.name, ",", ".")))
+		} else if strings.HasSuffix(compiled.name, "exe") && !strings.HasSuffix(compiled.name, ".exe") {
+			notes = append(notes, fmt.Sprintf("el nombre %q termina en \"exe\" sin punto", selector.Name))
+		}
+		if strings.ContainsAny(compiled.name, `/\`) {
+			notes = append(notes, fmt.Sprintf("el nombre %q contiene una ruta; use path_prefix", selector.Name))
+		}
+		if strings.ContainsAny(compiled.name, "*?[") {
+			if _, err := path.Match(compiled.name, ""); err != nil {
+				return compiled, notes, fmt.Errorf("el patrón glob %q es inválido: %v", selector.Name, err)
+			}
+			compiled.glob = true
+		}
+	}
+
+	if selector.Pattern != "" {
+		pattern, err := regexp.Compile("(?i)" + selector.Pattern)
+		if err != nil {
+			return compiled, notes, fmt.Errorf("la expresión regular %q es inválida: %v", selector.Pattern, err)
+		}
+		compiled.pattern = pattern
+	}
+	return compiled, notes, nil
+}
+
+// normalizePath pasa la ruta a minúsculas con separadores "/" para comparar
+// rutas de Windows y de Linux de la misma forma.
+func normalizePath(p string) string {
+	return strings.ReplaceAll(strings.ToLower(p), `\`, "/")
+}
+
+// matchesName evalúa solo los criterios sobre el nombre del ejecutable.
+func (s compiledSelector) matchesName(processName string) bool {
+	name := strings.ToLower(processName)
+	if s.name != "" {
+		if s.glob {
+			if ok, _ := path.Match(s.name, name); !ok {
+				return false
+			}
+		} else if s.name != name {
+			return false
+		}
+	}
+	return s.pattern == nil || s.pattern.MatchString(processName)
+}
+
+func (s compiledSelector) matches(process ProcessInfo) bool {
+	if !s.matchesName(process.Name) {
+		return false
+	}
+	if s.pathPrefix != "" && !strings.HasPrefix(normalizePath(process.Path), s.pathPrefix) {
+		return false
+	}
+	if s.commandLine != "" && !strings.Contains(strings.ToLower(process.CommandLine), s.commandLine) {
+		return false
+	}
+	if s.parent != "" && s.parent != strings.ToLower(process.ParentName) {
+		return false
+	}
+	if s.publisher != "" && !strings.Contains(strings.ToLower(process.Publisher), s.publisher) {
+		return false
+	}
+	return true
+}
+
+// Match retorna los procesos que coinciden con al menos un selector,
+// conservando el orden de la tabla.
+func (m *ProcessMatcher) Match(processes []ProcessInfo) []ProcessInfo {
+	var result []ProcessInfo
+	for _, process := range processes {
+		for _, selector := range m.selectors {
+			if selector.matches(process) {
+				result = append(result, process)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Candidates retorna, en el orden de la tabla, los procesos que podrían
+// coincidir con algún selector según su nombre. Son los únicos que hace falta
+// describir (abrir el proceso) antes de llamar a Match.
+func (m *ProcessMatcher) Candidates(processes []ProcessInfo) []ProcessInfo {
+	var result []ProcessInfo
+	for _, process := range processes {
+		for _, selector := range m.selectors {
+			if selector.matchesName(process.Name) {
+				result = append(result, process)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// MayMatch indica si el proceso podría coincidir con algún selector mirando
+// solo su nombre. Se usa con los eventos de inicio de procesos, que aún no
+// traen ruta, línea de comandos ni editor. Los selectores sin nombre ni
+// patrón no se pueden prefiltrar y no despiertan al monitor: sus procesos se
+// encuentran en el ciclo normal.
+func (m *ProcessMatcher) MayMatch(process ProcessInfo) bool {
+	for _, selector := range m.selectors {
+		if selector.name == "" && selector.pattern == nil {
+			continue
+		}
+		if selector.matchesName(process.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// OptionsFor retorna cómo se restringe el proceso según el primer selector
+// que coincide con él, o la suspensión simple si ninguno coincide.
+func (m *ProcessMatcher) OptionsFor(process ProcessInfo) EnforcementOptions {
+	for _, selector := range m.selectors {
+		if selector.matches(process) {
+			return selector.options
+		}
+	}
+	return EnforcementOptions{Action: domain.EnforcementSuspend}
+}
+
+// ReportProcessSelectorWarnings informa al servidor los selectores de
+// procesos descartados o con probables errores de tipeo.
+func ReportProcessSelectorWarnings(cfg ConfigResponse, client string) {
+	_, warnings := NewProcessMatcher(processSelectorsFor(cfg, nil))
+	if len(warnings) == 0 {
+		return
+	}
+	for _, w := range warnings {
+		log.Printf("Selector de procesos %+v: %s\n", w.Selector, w.Reason)
+	}
+	report := map[string]interface{}{
+		"client":   client,
+		"warnings": warnings,
+	}
+	if err := SendWebSocketMessage("process_selector_warnings", report); err != nil {
+		log.Printf("Error reportando los avisos de selectores de procesos: %v\n", err)
+	}
+}
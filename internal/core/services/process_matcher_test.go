@@ -0,0 +1,194 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// fakeProcessTable es una tabla de procesos como la que entrega
+// ListApplicationsInCurrentSession en un equipo de agente.
+var fakeProcessTable = []ProcessInfo{
+	{ID: 100, Name: "explorer.exe", Path: `C:\Windows\explorer.exe`, Publisher: "Microsoft Corporation"},
+	{ID: 200, Name: "Clientes.exe", Path: `C:\Program Files\Almacontact\Clientes.exe`, ParentID: 100, ParentName: "explorer.exe", Publisher: "Almacontact S.A.S."},
+	{ID: 201, Name: "copia_clientes.exe", Path: `C:\Users\agente\Desktop\copia_clientes.exe`, ParentID: 100, ParentName: "explorer.exe", Publisher: "Almacontact S.A.S."},
+	{ID: 300, Name: "chrome.exe", Path: `C:\Program Files\Google\Chrome\Application\chrome.exe`, CommandLine: `"chrome.exe" --profile-directory=Default`, ParentID: 100, ParentName: "explorer.exe", Publisher: "Google LLC"},
+	{ID: 301, Name: "chrome.exe", Path: `C:\Program Files\Google\Chrome\Application\chrome.exe`, CommandLine: `"chrome.exe" --type=renderer`, ParentID: 300, ParentName: "chrome.exe", Publisher: "Google LLC"},
+	{ID: 400, Name: "cmd.exe", Path: `C:\Windows\System32\cmd.exe`, CommandLine: `cmd.exe /c cxc.bat`, ParentID: 200, ParentName: "Clientes.exe", Publisher: "Microsoft Corporation"},
+	{ID: 500, Name: "cxc.exe", Path: `C:\Apps\CXC\cxc.exe`, ParentID: 400, ParentName: "cmd.exe"},
+}
+
+func matchedIDs(t *testing.T, selectors ...domain.ProcessSelector) []uint32 {
+	t.Helper()
+	matcher, warnings := NewProcessMatcher(selectors)
+	for _, w := range warnings {
+		if w.Rejected {
+			t.Fatalf("selector %+v rechazado: %s", w.Selector, w.Reason)
+		}
+	}
+	var ids []uint32
+	for _, process := range matcher.Match(fakeProcessTable) {
+		ids = append(ids, process.ID)
+	}
+	return ids
+}
+
+func TestProcessMatcherSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector domain.ProcessSelector
+		want     []uint32
+	}{
+		{"nombre sin distinguir mayúsculas", domain.ProcessSelector{Name: "clientes.exe"}, []uint32{200}},
+		{"glob", domain.ProcessSelector{Name: "*clientes*.exe"}, []uint32{200, 201}},
+		{"expresión regular", domain.ProcessSelector{Pattern: `^(copia_)?clientes\.exe$`}, []uint32{200, 201}},
+		{"prefijo de ruta", domain.ProcessSelector{PathPrefix: `c:\program files\google\`}, []uint32{300, 301}},
+		{"prefijo de ruta con barras", domain.ProcessSelector{PathPrefix: "C:/Apps/CXC"}, []uint32{500}},
+		{"línea de comandos", domain.ProcessSelector{CommandLine: "--TYPE=renderer"}, []uint32{301}},
+		{"proceso padre", domain.ProcessSelector{Parent: "CLIENTES.EXE"}, []uint32{400}},
+		{"editor", domain.ProcessSelector{Publisher: "almacontact"}, []uint32{200, 201}},
+		{"criterios combinados", domain.ProcessSelector{Name: "chrome.exe", Parent: "explorer.exe"}, []uint32{300}},
+		{"sin coincidencias", domain.ProcessSelector{Name: "notepad.exe"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchedIDs(t, tt.selector); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("coincidencias = %v, se esperaba %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessMatcherAnySelector(t *testing.T) {
+	got := matchedIDs(t, domain.ProcessSelector{Name: "cxc.exe"}, domain.ProcessSelector{Name: "Clientes.exe"}, domain.ProcessSelector{Name: "CXC.EXE"})
+	if want := []uint32{200, 500}; !reflect.DeepEqual(got, want) {
+		t.Errorf("coincidencias = %v, se esperaba %v", got, want)
+	}
+}
+
+func TestProcessMatcherWarnings(t *testing.T) {
+	tests := []struct {
+		selector domain.ProcessSelector
+		contains string
+		rejected bool
+	}{
+		{domain.ProcessSelector{Name: "cxc,exe"}, `"cxc.exe"`, false},
+		{domain.ProcessSelector{Name: "clientesexe"}, "sin punto", false},
+		{domain.ProcessSelector{Name: " clientes.exe"}, "espacios", false},
+		{domain.ProcessSelector{Name: `C:\Apps\cxc.exe`}, "path_prefix", false},
+		{domain.ProcessSelector{Name: "[clientes.exe"}, "glob", true},
+		{domain.ProcessSelector{Pattern: "(clientes"}, "expresión regular", true},
+		{domain.ProcessSelector{}, "ningún criterio", true},
+		{domain.ProcessSelector{Name: "cxc.exe", Action: "freeze"}, "acción", true},
+		{domain.ProcessSelector{Name: "cxc.exe", Action: domain.EnforcementHide, Relaunch: true}, "relaunch", false},
+	}
+	for _, tt := range tests {
+		matcher, warnings := NewProcessMatcher([]domain.ProcessSelector{tt.selector})
+		found := false
+		for _, w := range warnings {
+			if strings.Contains(w.Reason, tt.contains) && w.Rejected == tt.rejected {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("selector %+v: avisos %+v, se esperaba uno con %q (rechazado=%v)", tt.selector, warnings, tt.contains, tt.rejected)
+		}
+		if tt.rejected && len(matcher.selectors) != 0 {
+			t.Errorf("selector %+v: se esperaba que se descartara", tt.selector)
+		}
+	}
+}
+
+func TestProcessMatcherTrimmedNameStillMatches(t *testing.T) {
+	if got := matchedIDs(t, domain.ProcessSelector{Name: " Clientes.exe "}); !reflect.DeepEqual(got, []uint32{200}) {
+		t.Errorf("coincidencias = %v, se esperaba [200]", got)
+	}
+}
+
+func TestProcessSelectorsForFallsBackToInitialProcesses(t *testing.T) {
+	cfg := ConfigResponse{ProcessSelectors: []domain.ProcessSelector{{Parent: "cmd.exe"}}}
+	got := processSelectorsFor(cfg, []string{"clientes.exe"})
+	want := []domain.ProcessSelector{{Name: "clientes.exe"}, {Parent: "cmd.exe"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("selectores = %+v, se esperaba %+v", got, want)
+	}
+}
+
+func TestEqualSelectors(t *testing.T) {
+	cfg := ConfigResponse{ProcessSelectors: []domain.ProcessSelector{{Parent: "cmd.exe"}}}
+	if !equalSelectors(processSelectorsFor(cfg, []string{"clientes.exe"}), processSelectorsFor(cfg, []string{"clientes.exe"})) {
+		t.Error("la misma configuración debía producir los mismos selectores")
+	}
+	tests := []struct {
+		name string
+		a, b []domain.ProcessSelector
+	}{
+		{"otra acción", []domain.ProcessSelector{{Name: "a.exe"}}, []domain.ProcessSelector{{Name: "a.exe", Action: domain.EnforcementSuspend}}},
+		{"otro orden", []domain.ProcessSelector{{Name: "a.exe"}, {Name: "b.exe"}}, []domain.ProcessSelector{{Name: "b.exe"}, {Name: "a.exe"}}},
+		{"uno más", []domain.ProcessSelector{{Name: "a.exe"}}, []domain.ProcessSelector{{Name: "a.exe"}, {Name: "b.exe"}}},
+	}
+	for _, tt := range tests {
+		if equalSelectors(tt.a, tt.b) {
+			t.Errorf("%s: los selectores se consideraron iguales", tt.name)
+		}
+	}
+}
+
+func TestProcessMatcherOptionsForFirstMatchingSelector(t *testing.T) {
+	matcher, _ := NewProcessMatcher([]domain.ProcessSelector{
+		{Name: "chrome.exe", Parent: "explorer.exe", Action: domain.EnforcementOverlay},
+		{Name: "chrome.exe", SuspendTree: true},
+	})
+	tests := []struct {
+		process ProcessInfo
+		want    EnforcementOptions
+	}{
+		{fakeProcessTable[3], EnforcementOptions{Action: domain.EnforcementOverlay}},
+		{fakeProcessTable[4], EnforcementOptions{Action: domain.EnforcementSuspend, SuspendTree: true}},
+		{fakeProcessTable[0], EnforcementOptions{Action: domain.EnforcementSuspend}},
+	}
+	for _, tt := range tests {
+		if got := matcher.OptionsFor(tt.process); got != tt.want {
+			t.Errorf("OptionsFor(%d) = %+v, se esperaba %+v", tt.process.ID, got, tt.want)
+		}
+	}
+}
+
+func TestProcessMatcherCandidatesOnlyByName(t *testing.T) {
+	tests := []struct {
+		name      string
+		selectors []domain.ProcessSelector
+		want      int
+	}{
+		{"nombre", []domain.ProcessSelector{{Name: "chrome.exe", Parent: "explorer.exe"}}, 2},
+		{"expresión regular", []domain.ProcessSelector{{Pattern: "^c"}}, 6},
+		{"sin nombre requiere describir todos", []domain.ProcessSelector{{PathPrefix: `C:\Apps`}}, len(fakeProcessTable)},
+	}
+	for _, tt := range tests {
+		matcher, _ := NewProcessMatcher(tt.selectors)
+		if got := matcher.Candidates(fakeProcessTable); len(got) != tt.want {
+			t.Errorf("%s: %d candidatos, se esperaba %d", tt.name, len(got), tt.want)
+		}
+	}
+}
+
+func TestProcessMatcherMayMatchSkipsUnfilterableSelectors(t *testing.T) {
+	tests := []struct {
+		name      string
+		selectors []domain.ProcessSelector
+		process   string
+		want      bool
+	}{
+		{"nombre", []domain.ProcessSelector{{Name: "chrome.exe", Parent: "explorer.exe"}}, "chrome.exe", true},
+		{"otro nombre", []domain.ProcessSelector{{Name: "chrome.exe"}}, "notepad.exe", false},
+		{"sin nombre ni patrón", []domain.ProcessSelector{{PathPrefix: `C:\Apps`}}, "notepad.exe", false},
+	}
+	for _, tt := range tests {
+		matcher, _ := NewProcessMatcher(tt.selectors)
+		if got := matcher.MayMatch(ProcessInfo{Name: tt.process}); got != tt.want {
+			t.Errorf("%s: MayMatch(%s) = %v, se esperaba %v", tt.name, tt.process, got, tt.want)
+		}
+	}
+}
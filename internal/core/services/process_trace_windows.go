@@ -0,0 +1,309 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// El vigilante por eventos usa una sesión ETW en tiempo real con el
+// proveedor Microsoft-Windows-Kernel-Process, el mismo que alimenta a
+// Win32_ProcessStartTrace de WMI. Crear la sesión requiere privilegios de
+// administrador (o pertenecer a "Performance Log Users"), por lo que sin
+// ellos se vuelve a la enumeración periódica.
+
+var (
+	advapi32           = windows.NewLazySystemDLL("advapi32.dll")
+	procStartTraceW    = advapi32.NewProc("StartTraceW")
+	procControlTraceW  = advapi32.NewProc("ControlTraceW")
+	procEnableTraceEx2 = advapi32.NewProc("EnableTraceEx2")
+	procOpenTraceW     = advapi32.NewProc("OpenTraceW")
+	procProcessTrace   = advapi32.NewProc("ProcessTrace")
+	procCloseTrace     = advapi32.NewProc("CloseTrace")
+)
+
+const (
+	processTraceSessionName = "ScrapeBlocker-ProcessStart"
+
+	wnodeFlagTracedGUID          = 0x00020000
+	eventTraceRealTimeMode       = 0x00000100
+	eventTraceControlStop        = 1
+	eventControlEnableProvider   = 1
+	traceLevelInformation        = 4
+	kernelProcessKeywordProcess  = 0x10
+	kernelProcessEventStart      = 1
+	processTraceModeRealTime     = 0x00000100
+	processTraceModeEventRecord  = 0x10000000
+	invalidProcessTraceHandle    = ^uint64(0)
+	errorAlreadyExists           = 183
+	processTraceEventsBufferSize = 256
+)
+
+// kernelProcessProvider es Microsoft-Windows-Kernel-Process.
+var kernelProcessProvider = windows.GUID{Data1: 0x22fb2cd6, Data2: 0x0e7b, Data3: 0x422b,
+	Data4: [8]byte{0xa0, 0xc7, 0x2f, 0xad, 0x1f, 0xd0, 0xe7, 0x16}}
+
+// wnodeHeader es WNODE_HEADER.
+type wnodeHeader struct {
+	BufferSize        uint32
+	ProviderID        uint32
+	HistoricalContext uint64
+	TimeStamp         int64
+	GUID              windows.GUID
+	ClientContext     uint32
+	Flags             uint32
+}
+
+// eventTraceProperties es EVENT_TRACE_PROPERTIES seguida del espacio para
+// el nombre de la sesión, que la API escribe a continuación.
+type eventTraceProperties struct {
+	Wnode               wnodeHeader
+	BufferSize          uint32
+	MinimumBuffers      uint32
+	MaximumBuffers      uint32
+	MaximumFileSize     uint32
+	LogFileMode         uint32
+	FlushTimer          uint32
+	EnableFlags         uint32
+	AgeLimit            int32
+	NumberOfBuffers     uint32
+	FreeBuffers         uint32
+	EventsLost          uint32
+	BuffersWritten      uint32
+	LogBuffersLost      uint32
+	RealTimeBuffersLost uint32
+	LoggerThreadID      windows.Handle
+	LogFileNameOffset   uint32
+	LoggerNameOffset    uint32
+	loggerName          [len(processTraceSessionName) + 1]uint16
+}
+
+// eventTraceLogfile es EVENT_TRACE_LOGFILEW. CurrentEvent (EVENT_TRACE) y
+// LogfileHeader (TRACE_LOGFILE_HEADER) no se usan y se reservan como bytes.
+type eventTraceLogfile struct {
+	LogFileName         *uint16
+	LoggerName          *uint16
+	CurrentTime         int64
+	BuffersRead         uint32
+	ProcessTraceMode    uint32
+	CurrentEvent        [88]byte
+	LogfileHeader       [280]byte
+	BufferCallback      uintptr
+	BufferSize          uint32
+	Filled              uint32
+	EventsLost          uint32
+	EventRecordCallback uintptr
+	IsKernelTrace       uint32
+	Context             uintptr
+}
+
+// eventRecord es EVENT_RECORD con su EVENT_HEADER.
+type eventRecord struct {
+	Size              uint16
+	HeaderType        uint16
+	Flags             uint16
+	EventProperty     uint16
+	ThreadID          uint32
+	ProcessID         uint32
+	TimeStamp         int64
+	ProviderID        windows.GUID
+	EventID           uint16
+	Version           uint8
+	Channel           uint8
+	Level             uint8
+	Opcode            uint8
+	Task              uint16
+	Keyword           uint64
+	ProcessorTime     uint64
+	ActivityID        windows.GUID
+	BufferContext     uint32
+	ExtendedDataCount uint16
+	UserDataLength    uint16
+	ExtendedData      uintptr
+	UserData          *byte
+	UserContext       uintptr
+}
+
+// processTraceStart son los datos del evento ProcessStart que usa el
+// vigilante. Los primeros campos del evento (ProcessID, CreateTime,
+// ParentProcessID, SessionID) son iguales en todas sus versiones.
+type processTraceStart struct {
+	pid       uint32
+	parentID  uint32
+	sessionID uint32
+	startTime time.Time
+}
+
+var (
+	// processTraceCallback se crea una sola vez: las callbacks de Windows
+	// son un recurso limitado del runtime.
+	processTraceCallback     uintptr
+	processTraceCallbackOnce sync.Once
+
+	processTraceStarts   chan<- processTraceStart
+	processTraceStartsMu sync.Mutex
+)
+
+// onProcessTraceEvent recibe cada evento de la sesión en el hilo de ETW;
+// solo copia los datos y los entrega sin bloquear.
+func onProcessTraceEvent(record *eventRecord) uintptr {
+	if record.ProviderID != kernelProcessProvider || record.EventID != kernelProcessEventStart || record.UserDataLength < 20 {
+		return 0
+	}
+	data := unsafe.Slice(record.UserData, record.UserDataLength)
+	start := processTraceStart{
+		pid:       binary.LittleEndian.Uint32(data[0:]),
+		startTime: filetimeToTime(int64(binary.LittleEndian.Uint64(data[4:]))),
+		parentID:  binary.LittleEndian.Uint32(data[12:]),
+		sessionID: binary.LittleEndian.Uint32(data[16:]),
+	}
+
+	processTraceStartsMu.Lock()
+	defer processTraceStartsMu.Unlock()
+	if processTraceStarts != nil {
+		select {
+		case processTraceStarts <- start:
+		default:
+		}
+	}
+	return 0
+}
+
+// watchProcessStartEvents inicia la sesión ETW y entrega en events cada
+// proceso que inicia hasta que se cancele ctx; entonces detiene la sesión y
+// cierra events. Si la sesión se detiene desde afuera, sigue comparando la
+// lista de PIDs cada interval. Retorna error si no se pudo iniciar, sin tocar
+// events.
+func watchProcessStartEvents(ctx context.Context, interval time.Duration, events chan<- ProcessStartEvent) error {
+	if unsafe.Sizeof(uintptr(0)) != 8 {
+		return fmt.Errorf("la sesión ETW solo está disponible en 64 bits")
+	}
+	if err := procStartTraceW.Find(); err != nil {
+		return err
+	}
+
+	properties := newProcessTraceProperties()
+	name, _ := windows.UTF16PtrFromString(processTraceSessionName)
+	var session uint64
+	ret, _, _ := procStartTraceW.Call(uintptr(unsafe.Pointer(&session)), uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(properties)))
+	if ret == errorAlreadyExists {
+		// Quedó de una ejecución anterior que no terminó limpiamente.
+		stopProcessTrace(0)
+		properties = newProcessTraceProperties()
+		ret, _, _ = procStartTraceW.Call(uintptr(unsafe.Pointer(&session)), uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(properties)))
+	}
+	if ret != 0 {
+		return fmt.Errorf("error iniciando la sesión ETW: %v", windows.Errno(ret))
+	}
+
+	ret, _, _ = procEnableTraceEx2.Call(uintptr(session), uintptr(unsafe.Pointer(&kernelProcessProvider)),
+		eventControlEnableProvider, traceLevelInformation, kernelProcessKeywordProcess, 0, 0, 0)
+	if ret != 0 {
+		stopProcessTrace(session)
+		return fmt.Errorf("error habilitando el proveedor de procesos: %v", windows.Errno(ret))
+	}
+
+	processTraceCallbackOnce.Do(func() {
+		processTraceCallback = windows.NewCallback(onProcessTraceEvent)
+	})
+	logfile := eventTraceLogfile{
+		LoggerName:          name,
+		ProcessTraceMode:    processTraceModeRealTime | processTraceModeEventRecord,
+		EventRecordCallback: processTraceCallback,
+	}
+	consumer, _, _ := procOpenTraceW.Call(uintptr(unsafe.Pointer(&logfile)))
+	if uint64(consumer) == invalidProcessTraceHandle {
+		stopProcessTrace(session)
+		return fmt.Errorf("error abriendo la sesión ETW")
+	}
+
+	starts := make(chan processTraceStart, processTraceEventsBufferSize)
+	processTraceStartsMu.Lock()
+	processTraceStarts = starts
+	processTraceStartsMu.Unlock()
+
+	// ProcessTrace bloquea el hilo hasta que se cierre la sesión.
+	traceDone := make(chan struct{})
+	go func() {
+		defer close(traceDone)
+		handle := uint64(consumer)
+		if ret, _, _ := procProcessTrace.Call(uintptr(unsafe.Pointer(&handle)), 1, 0, 0); ret != 0 {
+			log.Printf("La sesión ETW de procesos terminó: %v\n", windows.Errno(ret))
+		}
+	}()
+
+	closeTrace := func() {
+		processTraceStartsMu.Lock()
+		processTraceStarts = nil
+		processTraceStartsMu.Unlock()
+		procCloseTrace.Call(consumer)
+		stopProcessTrace(session)
+	}
+
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case <-ctx.Done():
+				closeTrace()
+				<-traceDone
+				return
+			case <-traceDone:
+				// La sesión se detuvo desde afuera (p. ej. con logman); sin
+				// eventos se vuelve a la enumeración periódica.
+				log.Println("La sesión ETW de procesos se detuvo, se pasa a la enumeración periódica")
+				closeTrace()
+				pollProcessStarts(ctx, interval, events)
+				return
+			case start := <-starts:
+				process := ProcessInfo{ID: start.pid, ParentID: start.parentID, SessionID: start.sessionID, StartTime: start.startTime}
+				process.Name = processImageName(start.pid)
+				select {
+				case events <- ProcessStartEvent{Process: process, At: time.Now()}:
+				default:
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func newProcessTraceProperties() *eventTraceProperties {
+	properties := &eventTraceProperties{}
+	properties.Wnode.BufferSize = uint32(unsafe.Sizeof(*properties))
+	properties.Wnode.Flags = wnodeFlagTracedGUID
+	properties.Wnode.ClientContext = 1
+	properties.LogFileMode = eventTraceRealTimeMode
+	properties.FlushTimer = 1
+	properties.LoggerNameOffset = uint32(unsafe.Offsetof(properties.loggerName))
+	return properties
+}
+
+// stopProcessTrace detiene la sesión indicada o, con 0, la del mismo nombre.
+func stopProcessTrace(session uint64) {
+	properties := newProcessTraceProperties()
+	name, _ := windows.UTF16PtrFromString(processTraceSessionName)
+	procControlTraceW.Call(uintptr(session), uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(properties)), eventTraceControlStop)
+}
+
+// processImageName retorna el nombre del ejecutable de un proceso recién
+// iniciado. Es el único proceso que se abre por evento.
+func processImageName(pid uint32) string {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	if err != nil {
+		return ""
+	}
+	defer windows.CloseHandle(handle)
+	path, err := processImagePath(handle)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(path)
+}
@@ -0,0 +1,36 @@
+package services
+
+// descendantsOf retorna los descendientes de root en la tabla de procesos,
+// de arriba hacia abajo (hijos antes que nietos). Un hijo debe haber iniciado
+// después que su padre: así se descartan procesos cuyo ParentID apunta a un
+// PID ya reutilizado por otro proceso.
+func descendantsOf(root ProcessInfo, table []ProcessInfo) []ProcessInfo {
+	children := make(map[uint32][]ProcessInfo)
+	for _, process := range table {
+		if process.ID != process.ParentID {
+			children[process.ParentID] = append(children[process.ParentID], process)
+		}
+	}
+
+	var descendants []ProcessInfo
+	visited := map[uint32]bool{root.ID: true}
+	queue := []ProcessInfo{root}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, child := range children[parent.ID] {
+			if visited[child.ID] || startedBefore(child, parent) {
+				continue
+			}
+			visited[child.ID] = true
+			descendants = append(descendants, child)
+			queue = append(queue, child)
+		}
+	}
+	return descendants
+}
+
+// startedBefore indica si a inició antes que b, cuando ambas horas se conocen.
+func startedBefore(a, b ProcessInfo) bool {
+	return !a.StartTime.IsZero() && !b.StartTime.IsZero() && a.StartTime.Before(b.StartTime)
+}
@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultProcessWatchInterval es el intervalo con que se revisa si iniciaron
+// procesos nuevos. Es mucho menor que el ciclo del monitor porque solo se
+// enumeran PIDs y nombres, sin abrir los procesos.
+const DefaultProcessWatchInterval = 250 * time.Millisecond
+
+// ProcessStartEvent informa que un proceso acaba de iniciar. Solo trae el
+// nombre, el PID y el padre; el monitor completa el resto en su ciclo.
+type ProcessStartEvent struct {
+	Process ProcessInfo
+	At      time.Time
+}
+
+// WatchProcessStarts entrega un evento por cada proceso que inicia, hasta que
+// se cancele ctx. En Windows, con privilegios de administrador, se reciben
+// los eventos del proveedor de procesos del kernel (ETW). Sin ellos, o en
+// Linux, donde el conector de procesos de netlink requiere CAP_NET_ADMIN, se
+// compara la lista de PIDs del sistema en cada intervalo.
+func WatchProcessStarts(ctx context.Context, interval time.Duration) <-chan ProcessStartEvent {
+	events := make(chan ProcessStartEvent, 64)
+	err := watchProcessStartEvents(ctx, interval, events)
+	if err == nil {
+		log.Println("Vigilante de procesos por eventos del sistema")
+		return events
+	}
+	log.Printf("Vigilante de procesos por enumeración periódica: %v\n", err)
+
+	go func() {
+		defer close(events)
+		pollProcessStarts(ctx, interval, events)
+	}()
+	return events
+}
+
+// pollProcessStarts compara la lista de PIDs del sistema en cada intervalo y
+// entrega en events los procesos nuevos hasta que se cancele ctx. No cierra
+// events.
+func pollProcessStarts(ctx context.Context, interval time.Duration, events chan<- ProcessStartEvent) {
+	known, err := snapshotProcesses()
+	if err != nil {
+		log.Printf("Error iniciando el vigilante de procesos: %v\n", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current, err := snapshotProcesses()
+		if err != nil {
+			continue
+		}
+		now := time.Now()
+		for pid, process := range current {
+			if _, ok := known[pid]; ok {
+				continue
+			}
+			select {
+			case events <- ProcessStartEvent{Process: process, At: now}:
+			default:
+			}
+		}
+		known = current
+	}
+}
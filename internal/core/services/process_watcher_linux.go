@@ -0,0 +1,54 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotProcesses enumera los procesos de /proc con su nombre y su padre,
+// sin leer el resto de su información. comm se trunca a 15 caracteres, así
+// que se prefiere el nombre del ejecutable cuando se puede leer.
+func snapshotProcesses() (map[uint32]ProcessInfo, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	processes := make(map[uint32]ProcessInfo, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + entry.Name() + "/stat")
+		if err != nil {
+			continue
+		}
+		open, close := strings.IndexByte(string(stat), '('), strings.LastIndexByte(string(stat), ')')
+		if open < 0 || close < open {
+			continue
+		}
+		process := ProcessInfo{ID: uint32(pid), Name: string(stat[open+1 : close])}
+		if exe, err := os.Readlink("/proc/" + entry.Name() + "/exe"); err == nil {
+			process.Name = filepath.Base(strings.TrimSuffix(exe, " (deleted)"))
+		}
+		if fields := strings.Fields(string(stat[close+1:])); len(fields) > 1 {
+			if parentID, err := strconv.ParseUint(fields[1], 10, 32); err == nil {
+				process.ParentID = uint32(parentID)
+			}
+		}
+		processes[process.ID] = process
+	}
+	return processes, nil
+}
+
+// watchProcessStartEvents no está disponible en Linux: el conector de
+// procesos de netlink requiere CAP_NET_ADMIN y el agente corre como el
+// usuario.
+func watchProcessStartEvents(ctx context.Context, interval time.Duration, events chan<- ProcessStartEvent) error {
+	return fmt.Errorf("los eventos de inicio de procesos no están disponibles en Linux")
+}
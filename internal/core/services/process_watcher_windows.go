@@ -0,0 +1,29 @@
+package services
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// snapshotProcesses enumera los procesos con Toolhelp, que entrega nombre y
+// padre sin abrir cada proceso.
+func snapshotProcesses() (map[uint32]ProcessInfo, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var entry windows.ProcessEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	processes := make(map[uint32]ProcessInfo)
+	for err = windows.Process32First(snapshot, &entry); err == nil; err = windows.Process32Next(snapshot, &entry) {
+		processes[entry.ProcessID] = ProcessInfo{
+			ID:       entry.ProcessID,
+			Name:     windows.UTF16ToString(entry.ExeFile[:]),
+			ParentID: entry.ParentProcessID,
+		}
+	}
+	return processes, nil
+}
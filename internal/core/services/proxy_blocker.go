@@ -0,0 +1,272 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyListen es la dirección del proxy local si la configuración no
+// indica otra. Chrome debe apuntar a ella (p. ej. --proxy-server=127.0.0.1:8899).
+const DefaultProxyListen = "127.0.0.1:8899"
+
+// blockedPage es la página que se sirve a las peticiones HTTP bloqueadas.
+const blockedPage = `<!DOCTYPE html>
+<html lang="es">
+<head><meta charset="utf-8"><title>ScrapeBlocker - Sitio bloqueado</title>
+<style>
+body { font-family: Segoe UI, Arial, sans-serif; background: #1b0088; color: #fff; display: flex; align-items: center; justify-content: center; height: 100vh; margin: 0; }
+main { text-align: center; max-width: 560px; }
+h1 { font-size: 28px; margin-bottom: 8px; }
+p { opacity: .85; }
+</style></head>
+<body><main>
+<h1>Sitio bloqueado</h1>
+<p>Este sitio no está disponible mientras no tengas una interacción en curso.</p>
+<p>ScrapeBlocker - Almacontact</p>
+</main></body>
+</html>`
+
+var hopByHopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// ProxyBlocker es un proxy de reenvío local. Las peticiones HTTP se filtran
+// por URL completa (host y ruta) y los túneles CONNECT por el host pedido y
+// por el SNI del ClientHello, ya que sin interceptar TLS no se ve la ruta.
+type ProxyBlocker struct {
+	listener  net.Listener
+	server    *http.Server
+	transport *http.Transport
+
+	mu    sync.RWMutex
+	rules []URLRule
+}
+
+// NewProxyBlocker inicia el proxy en listenAddr.
+func NewProxyBlocker(listenAddr string) (*ProxyBlocker, error) {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error escuchando el proxy en %s: %v", listenAddr, err)
+	}
+
+	b := &ProxyBlocker{
+		listener: listener,
+		transport: &http.Transport{
+			Proxy:               nil,
+			DialContext:         (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	b.server = &http.Server{Handler: b, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := b.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Error en el proxy de bloqueo: %v\n", err)
+		}
+	}()
+	log.Printf("Proxy de bloqueo escuchando en %s\n", listener.Addr())
+	return b, nil
+}
+
+func (b *ProxyBlocker) Name() string {
+	return BlockingBackendProxy
+}
+
+// Addr retorna la dirección en la que escucha el proxy.
+func (b *ProxyBlocker) Addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *ProxyBlocker) Apply(rules []URLRule) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rules = append([]URLRule(nil), rules...)
+	return nil
+}
+
+func (b *ProxyBlocker) Clear() error {
+	return b.Apply(nil)
+}
+
+func (b *ProxyBlocker) Close() error {
+	b.transport.CloseIdleConnections()
+	return b.server.Close()
+}
+
+// blockedURL indica si alguna regla cubre la URL completa.
+func (b *ProxyBlocker) blockedURL(r *http.Request) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, rule := range b.rules {
+		if rule.MatchesURL(r.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedHost indica si alguna regla sin ruta cubre el host. Las reglas con
+// ruta no bloquean túneles CONNECT porque la ruta no es visible; se informan
+// al servidor con ReportRejectedURLs.
+func (b *ProxyBlocker) blockedHost(host string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, rule := range b.rules {
+		if rule.Path == "" && rule.MatchesHost(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *ProxyBlocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		b.serveConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "ScrapeBlocker: solo se admiten peticiones de proxy", http.StatusBadRequest)
+		return
+	}
+	if b.blockedURL(r) {
+		log.Printf("Proxy: petición bloqueada %s\n", r.URL)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, blockedPage)
+		return
+	}
+
+	outgoing := r.Clone(r.Context())
+	outgoing.RequestURI = ""
+	removeHopByHopHeaders(outgoing.Header)
+
+	resp, err := b.transport.RoundTrip(outgoing)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ScrapeBlocker: error contactando %s: %v", r.URL.Host, err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopByHopHeaders(resp.Header)
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// serveConnect abre el túnel si ni el host pedido ni el SNI están bloqueados.
+func (b *ProxyBlocker) serveConnect(w http.ResponseWriter, r *http.Request) {
+	host := r.URL.Hostname()
+	if host == "" {
+		host, _, _ = net.SplitHostPort(r.Host)
+	}
+	if b.blockedHost(host) {
+		log.Printf("Proxy: túnel bloqueado hacia %s\n", r.Host)
+		http.Error(w, "ScrapeBlocker: sitio bloqueado", http.StatusForbidden)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "ScrapeBlocker: túnel no soportado", http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer clientConn.Close()
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	// Leer el ClientHello para conocer el SNI real antes de contactar el destino.
+	clientConn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	serverName, hello := peekServerName(clientBuf.Reader)
+	clientConn.SetReadDeadline(time.Time{})
+	if serverName != "" && b.blockedHost(serverName) {
+		log.Printf("Proxy: túnel bloqueado por SNI %s\n", serverName)
+		return
+	}
+
+	targetConn, err := net.DialTimeout("tcp", r.Host, 10*time.Second)
+	if err != nil {
+		return
+	}
+	defer targetConn.Close()
+
+	if _, err := targetConn.Write(hello); err != nil {
+		return
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(targetConn, clientBuf)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, targetConn)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+var errHelloRead = errors.New("ClientHello leído")
+
+// peekServerName lee el ClientHello TLS del cliente y retorna el SNI junto
+// con los bytes consumidos, que deben reenviarse al destino. Si el tráfico no
+// es TLS retorna un SNI vacío.
+func peekServerName(reader *bufio.Reader) (string, []byte) {
+	var consumed bytes.Buffer
+	var serverName string
+	conn := readOnlyConn{reader: io.TeeReader(reader, &consumed)}
+	tls.Server(conn, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	return serverName, consumed.Bytes()
+}
+
+// readOnlyConn permite que crypto/tls lea el ClientHello sin poder escribir
+// nada hacia el cliente.
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func removeHopByHopHeaders(header http.Header) {
+	for _, field := range strings.Split(header.Get("Connection"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			header.Del(field)
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/policy"
+)
+
+// startFakeOrigin inicia un servidor HTTP que responde con el host y la ruta
+// pedidos.
+func startFakeOrigin(t *testing.T) *httptest.Server {
+	t.Helper()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "origen "+r.Host+r.URL.Path)
+	}))
+	t.Cleanup(origin.Close)
+	return origin
+}
+
+// newTestProxyBlocker inicia el proxy en un puerto alto. Todas las conexiones
+// HTTP salientes van a origin, sin importar el host pedido.
+func newTestProxyBlocker(t *testing.T, origin *httptest.Server, entries ...string) *ProxyBlocker {
+	t.Helper()
+	blocker, err := NewProxyBlocker("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error iniciando el proxy: %v", err)
+	}
+	t.Cleanup(func() { blocker.Close() })
+	if origin != nil {
+		originAddr := origin.Listener.Addr().String()
+		blocker.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, originAddr)
+		}
+	}
+	rules, rejected := ParseURLRules(entries)
+	if len(rejected) > 0 {
+		t.Fatalf("entradas rechazadas: %+v", rejected)
+	}
+	if err := blocker.Apply(rules); err != nil {
+		t.Fatalf("error aplicando las reglas: %v", err)
+	}
+	return blocker
+}
+
+// getThroughProxy pide rawURL a través del proxy y retorna el código y el cuerpo.
+func getThroughProxy(t *testing.T, proxyAddr, rawURL string) (int, string) {
+	t.Helper()
+	proxyURL, _ := url.Parse("http://" + proxyAddr)
+	client := http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		t.Fatalf("error pidiendo %s: %v", rawURL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// connectThroughProxy abre un túnel CONNECT hacia target y retorna la
+// conexión y el código de la respuesta del proxy.
+func connectThroughProxy(t *testing.T, proxyAddr, target string) (net.Conn, int) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxyAddr, time.Second)
+	if err != nil {
+		t.Fatalf("error conectando con el proxy: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		t.Fatalf("error leyendo la respuesta a CONNECT: %v", err)
+	}
+	return conn, resp.StatusCode
+}
+
+func TestProxyBlockerHTTP(t *testing.T) {
+	origin := startFakeOrigin(t)
+	blocker := newTestProxyBlocker(t, origin, "*.youtube.com", "whatsapp.com/business")
+
+	tests := []struct {
+		name    string
+		url     string
+		status  int
+		contain string
+	}{
+		{"comodín", "http://m.youtube.com/watch", http.StatusForbidden, "Sitio bloqueado"},
+		{"ruta bloqueada", "http://whatsapp.com/business/api", http.StatusForbidden, "Sitio bloqueado"},
+		{"otra ruta del mismo host", "http://whatsapp.com/download", http.StatusOK, "origen whatsapp.com/download"},
+		{"host no bloqueado", "http://example.com/", http.StatusOK, "origen example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := getThroughProxy(t, blocker.Addr(), tt.url)
+			if status != tt.status {
+				t.Fatalf("código = %d, se esperaba %d", status, tt.status)
+			}
+			if !strings.Contains(body, tt.contain) {
+				t.Errorf("cuerpo = %q, se esperaba que contuviera %q", body, tt.contain)
+			}
+		})
+	}
+}
+
+func TestProxyBlockerConnect(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "origen seguro")
+	}))
+	t.Cleanup(origin.Close)
+	blocker := newTestProxyBlocker(t, nil, "youtube.com", "whatsapp.com/business")
+
+	t.Run("host bloqueado", func(t *testing.T) {
+		if _, status := connectThroughProxy(t, blocker.Addr(), "youtube.com:443"); status != http.StatusForbidden {
+			t.Errorf("código = %d, se esperaba %d", status, http.StatusForbidden)
+		}
+	})
+
+	t.Run("SNI bloqueado", func(t *testing.T) {
+		conn, status := connectThroughProxy(t, blocker.Addr(), origin.Listener.Addr().String())
+		if status != http.StatusOK {
+			t.Fatalf("código = %d, el túnel hacia la IP debía abrirse", status)
+		}
+		client := tls.Client(conn, &tls.Config{ServerName: "youtube.com", InsecureSkipVerify: true})
+		if err := client.Handshake(); err == nil {
+			t.Error("el proxy dejó pasar el ClientHello con SNI bloqueado")
+		}
+	})
+
+	t.Run("ruta no aplica al túnel", func(t *testing.T) {
+		conn, status := connectThroughProxy(t, blocker.Addr(), origin.Listener.Addr().String())
+		if status != http.StatusOK {
+			t.Fatalf("código = %d, se esperaba %d", status, http.StatusOK)
+		}
+		client := tls.Client(conn, &tls.Config{ServerName: "whatsapp.com", InsecureSkipVerify: true})
+		if err := client.Handshake(); err != nil {
+			t.Fatalf("error en el túnel permitido: %v", err)
+		}
+		io.WriteString(client, "GET /business HTTP/1.1\r\nHost: whatsapp.com\r\nConnection: close\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatalf("error leyendo por el túnel: %v", err)
+		}
+		defer resp.Body.Close()
+		if body, _ := io.ReadAll(resp.Body); string(body) != "origen seguro" {
+			t.Errorf("cuerpo = %q", body)
+		}
+	})
+}
+
+// fakeChrome atiende las llamadas que hace applyURLVerdicts.
+type fakeChrome struct {
+	ChromeService
+}
+
+func (fakeChrome) RedirectBlockedTabs(urlsToBlock []string) error { return nil }
+func (fakeChrome) NavigateBackToPreviousURLs() error              { return nil }
+
+func TestProxyBlockerSwapsRulesOnConfigChange(t *testing.T) {
+	previous := GetCurrentConfig()
+	t.Cleanup(func() { SetCurrentConfig(previous) })
+
+	origin := startFakeOrigin(t)
+	engine, _ := policy.NewEngine(nil)
+	blockers := &blockerHolder{}
+	t.Cleanup(blockers.close)
+	var state appliedURLState
+	var previousDecision policy.Decision
+
+	// cycle reproduce el paso del monitor que aplica las URLs de la
+	// configuración vigente.
+	cycle := func() *ProxyBlocker {
+		cfg := GetCurrentConfig()
+		blocker := blockers.get(cfg)
+		proxy, ok := blocker.(*ProxyBlocker)
+		if !ok {
+			t.Fatalf("backend = %s, se esperaba el proxy", blocker.Name())
+		}
+		originAddr := origin.Listener.Addr().String()
+		proxy.transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, originAddr)
+		}
+		decision := engine.Evaluate(policy.Facts{UserActive: true}, policy.Targets{URLs: validURLEntries(cfg.UrlsToBlock)})
+		applyURLVerdicts(fakeChrome{}, blocker, &state, decision, decision.Diff(previousDecision))
+		previousDecision = decision
+		return proxy
+	}
+
+	cfg := ConfigResponse{BlockingBackend: BlockingBackendProxy, ProxyListen: "127.0.0.1:0", UrlsToBlock: []string{"youtube.com"}}
+	SetCurrentConfig(cfg)
+	proxy := cycle()
+	if status, _ := getThroughProxy(t, proxy.Addr(), "http://youtube.com/"); status != http.StatusForbidden {
+		t.Fatalf("youtube.com: código = %d antes del cambio, se esperaba %d", status, http.StatusForbidden)
+	}
+
+	cfg.UrlsToBlock = []string{"facebook.com"}
+	SetCurrentConfig(cfg)
+	if swapped := cycle(); swapped != proxy {
+		t.Fatal("el cambio de URLs no debía recrear el proxy")
+	}
+	if status, _ := getThroughProxy(t, proxy.Addr(), "http://youtube.com/"); status != http.StatusOK {
+		t.Errorf("youtube.com: código = %d tras el cambio, se esperaba %d", status, http.StatusOK)
+	}
+	if status, _ := getThroughProxy(t, proxy.Addr(), "http://facebook.com/"); status != http.StatusForbidden {
+		t.Errorf("facebook.com: código = %d tras el cambio, se esperaba %d", status, http.StatusForbidden)
+	}
+}
+
+func TestDegradedURLs(t *testing.T) {
+	entries := []string{"youtube.com", "whatsapp.com/business", "https://x.com/i/flow"}
+	proxy := degradedURLs(ConfigResponse{BlockingBackend: BlockingBackendProxy, UrlsToBlock: entries})
+	if len(proxy) != 2 || proxy[0].Entry != "whatsapp.com/business" || !strings.Contains(proxy[0].Reason, "HTTPS") {
+		t.Errorf("proxy: degradadas = %+v", proxy)
+	}
+	hosts := degradedURLs(ConfigResponse{UrlsToBlock: entries})
+	if len(hosts) != 2 || !strings.Contains(hosts[1].Reason, "se bloquea todo x.com") {
+		t.Errorf("hosts: degradadas = %+v", hosts)
+	}
+}
@@ -0,0 +1,390 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/policy"
+)
+
+// Órdenes remotas que un supervisor puede enviar al agente.
+const (
+	CommandForceBlock      = "force_block"
+	CommandForceRelease    = "force_release"
+	CommandClearOverride   = "clear_override"
+	CommandReloadConfig    = "reload_config"
+	CommandDumpDiagnostics = "dump_diagnostics"
+	CommandRestoreTabs     = "restore_tabs"
+)
+
+const (
+	// maxOverrideDuration limita cuánto puede durar un bloqueo o una
+	// liberación forzada para que un olvido no deje al agente fijo.
+	maxOverrideDuration = 12 * time.Hour
+	// monitorCommandTimeout es lo que se espera a que el monitor atienda una orden.
+	monitorCommandTimeout = 10 * time.Second
+)
+
+// CommandPayload son los datos comunes de las órdenes remotas. ExpiresAt
+// descarta la orden si llega tarde (p. ej. encolada mientras el agente
+// estaba sin conexión). User indica a qué usuario aplica en el modo
+// multisesión; si se omite aplica al usuario del agente.
+type CommandPayload struct {
+	DurationMinutes int        `json:"duration_minutes,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	IssuedBy        string     `json:"issued_by,omitempty"`
+	User            string     `json:"user,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+}
+
+// Override es un bloqueo o una liberación forzada vigente.
+type Override struct {
+	Kind      string    `json:"kind"`
+	User      string    `json:"user"`
+	Until     time.Time `json:"until"`
+	IssuedBy  string    `json:"issued_by,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+}
+
+// Diagnostics es el volcado que se envía con dump_diagnostics.
+type Diagnostics struct {
+	Status      AgentStatus    `json:"status"`
+	Config      ConfigResponse `json:"config"`
+	Ledger      []LedgerEntry  `json:"ledger"`
+	Overrides   []Override     `json:"overrides,omitempty"`
+	Audit       []AuditEntry   `json:"audit,omitempty"`
+	GoVersion   string         `json:"go_version"`
+	OS          string         `json:"os"`
+	Hostname    string         `json:"hostname"`
+	Goroutines  int            `json:"goroutines"`
+	HeapAlloc   uint64         `json:"heap_alloc"`
+	CollectedAt time.Time      `json:"collected_at"`
+}
+
+var (
+	overrides     = make(map[string]Override)
+	overridesMu   sync.Mutex
+	overridesPath = DefaultOverridesPath()
+
+	// tabRestoreRequests son las órdenes restore_tabs pendientes. Las atiende
+	// el monitor en su propia goroutine, ya que es el dueño del ChromeService.
+	tabRestoreRequests   []chan error
+	tabRestoreRequestsMu sync.Mutex
+
+	// monitorWakeups adelanta el próximo ciclo del monitor para que una orden
+	// se refleje de inmediato.
+	monitorWakeups = make(chan struct{}, 1)
+)
+
+func init() {
+	RegisterMessageHandler(CommandForceBlock, handleOverrideCommand(policy.OverrideForceBlock))
+	RegisterMessageHandler(CommandForceRelease, handleOverrideCommand(policy.OverrideForceRelease))
+	RegisterMessageHandler(CommandClearOverride, handleClearOverride)
+	RegisterMessageHandler(CommandReloadConfig, handleReloadConfig)
+	RegisterMessageHandler(CommandDumpDiagnostics, handleDumpDiagnostics)
+	RegisterMessageHandler(CommandRestoreTabs, handleRestoreTabs)
+}
+
+// DefaultOverridesPath retorna la ubicación del archivo de órdenes vigentes,
+// junto al registro de restricciones.
+func DefaultOverridesPath() string {
+	return filepath.Join(filepath.Dir(DefaultLedgerPath()), "overrides.json")
+}
+
+// LoadOverrides carga las órdenes vigentes guardadas por una ejecución
+// anterior, de forma que reiniciar el agente no anule un force_block. Las que
+// vencieron mientras el agente no corría se descartan y quedan registradas en
+// la auditoría. Debe ejecutarse antes de iniciar el monitor.
+func LoadOverrides() error {
+	content, err := os.ReadFile(overridesPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error leyendo las órdenes vigentes: %v", err)
+	}
+	var saved []Override
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return fmt.Errorf("archivo de órdenes vigentes %s ilegible: %v", overridesPath, err)
+	}
+
+	var expired []Override
+	overridesMu.Lock()
+	now := time.Now()
+	for _, override := range saved {
+		if !now.Before(override.Until) {
+			expired = append(expired, override)
+			continue
+		}
+		overrides[userStateKey(override.User)] = override
+		log.Printf("Orden %s del usuario %s vigente hasta %s\n", override.Kind, override.User, override.Until.Format(time.RFC3339))
+	}
+	if len(expired) > 0 {
+		saveOverridesLocked()
+	}
+	overridesMu.Unlock()
+
+	for _, override := range expired {
+		auditExpired(override)
+	}
+	return nil
+}
+
+// saveOverridesLocked guarda las órdenes vigentes. Debe llamarse con
+// overridesMu tomado; un error se registra sin interrumpir la orden.
+func saveOverridesLocked() {
+	saved := make([]Override, 0, len(overrides))
+	for _, override := range overrides {
+		saved = append(saved, override)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].User < saved[j].User })
+	content, err := json.MarshalIndent(saved, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(overridesPath), 0755); err == nil {
+			err = writeFileAtomic(overridesPath, content)
+		}
+	}
+	if err != nil {
+		log.Printf("Error guardando las órdenes vigentes: %v\n", err)
+	}
+}
+
+// auditExpired deja en la auditoría el vencimiento de la orden.
+func auditExpired(override Override) {
+	log.Printf("Orden %s del usuario %s vencida\n", override.Kind, override.User)
+	recordAudit(AuditEntry{Command: override.Kind, MessageID: override.MessageID, IssuedBy: override.IssuedBy,
+		User: override.User, Result: AuditExpired})
+}
+
+// wakeMonitor adelanta el próximo ciclo del monitor.
+func wakeMonitor() {
+	select {
+	case monitorWakeups <- struct{}{}:
+	default:
+	}
+}
+
+// ActiveOverride retorna la orden vigente del usuario. Las vencidas se
+// descartan y quedan registradas en la auditoría.
+func ActiveOverride(name string) (Override, bool) {
+	overridesMu.Lock()
+	key := userStateKey(name)
+	override, ok := overrides[key]
+	if ok && !time.Now().Before(override.Until) {
+		delete(overrides, key)
+		saveOverridesLocked()
+		overridesMu.Unlock()
+		auditExpired(override)
+		return Override{}, false
+	}
+	overridesMu.Unlock()
+	return override, ok
+}
+
+// ActiveOverrides retorna las órdenes vigentes ordenadas por usuario.
+func ActiveOverrides() []Override {
+	overridesMu.Lock()
+	var names []string
+	for _, override := range overrides {
+		names = append(names, override.User)
+	}
+	overridesMu.Unlock()
+
+	var active []Override
+	for _, name := range names {
+		if override, ok := ActiveOverride(name); ok {
+			active = append(active, override)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].User < active[j].User })
+	return active
+}
+
+// overrideFor retorna el tipo de orden vigente del usuario para los hechos
+// de la política.
+func overrideFor(name string) string {
+	if override, ok := ActiveOverride(name); ok {
+		return override.Kind
+	}
+	return ""
+}
+
+// recordAudit registra la entrada sin interrumpir la orden si falla.
+func recordAudit(entry AuditEntry) {
+	if err := defaultAuditLog.Record(entry); err != nil {
+		log.Printf("Error registrando la auditoría de %s: %v\n", entry.Command, err)
+	}
+}
+
+// runCommand decodifica la orden, verifica que no haya vencido, la ejecuta y
+// deja la entrada de auditoría con el resultado.
+func runCommand(envelope Envelope, user *domain.User, run func(payload CommandPayload, target string) error) error {
+	var payload CommandPayload
+	err := decodePayload(envelope, &payload)
+	target := payload.User
+	if target == "" {
+		target = user.Name
+	}
+	if err == nil && payload.ExpiresAt != nil && time.Now().After(*payload.ExpiresAt) {
+		err = fmt.Errorf("la orden venció el %s", payload.ExpiresAt.Format(time.RFC3339))
+	}
+	if err == nil {
+		err = run(payload, target)
+	}
+
+	entry := AuditEntry{
+		MessageID:       envelope.ID,
+		Command:         envelope.Type,
+		IssuedBy:        payload.IssuedBy,
+		Reason:          payload.Reason,
+		User:            target,
+		DurationMinutes: payload.DurationMinutes,
+		Result:          AuditApplied,
+	}
+	if err != nil {
+		entry.Result = AuditRejected
+		entry.Error = err.Error()
+		log.Printf("Orden %s rechazada: %v\n", envelope.Type, err)
+	} else {
+		log.Printf("Orden %s aplicada (usuario %s, emitida por %s)\n", envelope.Type, target, payload.IssuedBy)
+	}
+	recordAudit(entry)
+	return err
+}
+
+// handleOverrideCommand crea el handler de force_block o force_release: la
+// orden reemplaza a la vigente del usuario y dura DurationMinutes.
+func handleOverrideCommand(kind string) MessageHandler {
+	return func(envelope Envelope, user *domain.User) error {
+		return runCommand(envelope, user, func(payload CommandPayload, target string) error {
+			duration := time.Duration(payload.DurationMinutes) * time.Minute
+			if duration <= 0 {
+				return fmt.Errorf("la orden %s requiere duration_minutes mayor que cero", envelope.Type)
+			}
+			if duration > maxOverrideDuration {
+				return fmt.Errorf("la duración máxima es de %v", maxOverrideDuration)
+			}
+
+			overridesMu.Lock()
+			overrides[userStateKey(target)] = Override{
+				Kind:      kind,
+				User:      target,
+				Until:     time.Now().Add(duration),
+				IssuedBy:  payload.IssuedBy,
+				Reason:    payload.Reason,
+				MessageID: envelope.ID,
+			}
+			saveOverridesLocked()
+			overridesMu.Unlock()
+			wakeMonitor()
+			return nil
+		})
+	}
+}
+
+// handleClearOverride cancela antes de tiempo la orden vigente del usuario.
+func handleClearOverride(envelope Envelope, user *domain.User) error {
+	return runCommand(envelope, user, func(payload CommandPayload, target string) error {
+		overridesMu.Lock()
+		_, ok := overrides[userStateKey(target)]
+		delete(overrides, userStateKey(target))
+		if ok {
+			saveOverridesLocked()
+		}
+		overridesMu.Unlock()
+		if !ok {
+			return fmt.Errorf("el usuario %s no tiene una orden vigente", target)
+		}
+		wakeMonitor()
+		return nil
+	})
+}
+
+// handleReloadConfig vuelve a obtener la configuración.
+func handleReloadConfig(envelope Envelope, user *domain.User) error {
+	return runCommand(envelope, user, func(payload CommandPayload, target string) error {
+		if err := refreshConfiguration(user); err != nil {
+			return err
+		}
+		wakeMonitor()
+		return nil
+	})
+}
+
+// handleDumpDiagnostics envía al servidor el estado completo del agente.
+func handleDumpDiagnostics(envelope Envelope, user *domain.User) error {
+	return runCommand(envelope, user, func(payload CommandPayload, target string) error {
+		return SendWebSocketMessage("diagnostics", collectDiagnostics())
+	})
+}
+
+// handleRestoreTabs devuelve las pestañas redirigidas a su URL anterior. Si
+// el bloqueo de URLs sigue vigente el monitor las volverá a redirigir, por lo
+// que normalmente se combina con force_release.
+func handleRestoreTabs(envelope Envelope, user *domain.User) error {
+	return runCommand(envelope, user, func(payload CommandPayload, target string) error {
+		reply := make(chan error, 1)
+		tabRestoreRequestsMu.Lock()
+		tabRestoreRequests = append(tabRestoreRequests, reply)
+		tabRestoreRequestsMu.Unlock()
+		wakeMonitor()
+
+		select {
+		case err := <-reply:
+			return err
+		case <-time.After(monitorCommandTimeout):
+			return fmt.Errorf("el monitor no atendió la orden en %v", monitorCommandTimeout)
+		}
+	})
+}
+
+// takeTabRestoreRequests retorna y vacía las órdenes restore_tabs pendientes.
+func takeTabRestoreRequests() []chan error {
+	tabRestoreRequestsMu.Lock()
+	defer tabRestoreRequestsMu.Unlock()
+	requests := tabRestoreRequests
+	tabRestoreRequests = nil
+	return requests
+}
+
+// serveTabRestoreRequests atiende las órdenes restore_tabs pendientes desde
+// la goroutine del monitor. Con chromeService nil (modo multisesión) se
+// rechazan.
+func serveTabRestoreRequests(chromeService ChromeService) {
+	for _, reply := range takeTabRestoreRequests() {
+		if chromeService == nil {
+			reply <- fmt.Errorf("la restauración de pestañas no está disponible en el modo multisesión")
+			continue
+		}
+		reply <- chromeService.NavigateBackToPreviousURLs()
+	}
+}
+
+// collectDiagnostics reúne el estado del agente para dump_diagnostics.
+func collectDiagnostics() Diagnostics {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	hostname, _ := os.Hostname()
+	return Diagnostics{
+		Status:      GetAgentStatus(),
+		Config:      GetCurrentConfig(),
+		Ledger:      defaultLedger.Entries(),
+		Overrides:   ActiveOverrides(),
+		Audit:       defaultAuditLog.Recent(),
+		GoVersion:   runtime.Version(),
+		OS:          runtime.GOOS + "/" + runtime.GOARCH,
+		Hostname:    hostname,
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   memStats.HeapAlloc,
+		CollectedAt: time.Now(),
+	}
+}
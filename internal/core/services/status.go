@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// AgentVersion es la versión del agente que se informa al servidor.
+const AgentVersion = "1.0.2"
+
+const (
+	// DefaultStatusReportInterval es cada cuánto se envía el estado al
+	// servidor si no hubo transiciones.
+	DefaultStatusReportInterval = time.Minute
+	// maxStatusErrors acota los errores que se conservan entre reportes.
+	maxStatusErrors = 20
+)
+
+// AgentStatus es el estado del agente que se expone al servidor.
+type AgentStatus struct {
+	Version        string              `json:"version"`
+	User           string              `json:"user,omitempty"`
+	Client         string              `json:"client,omitempty"`
+	Connection     ConnectionState     `json:"connection"`
+	UserActive     bool                `json:"user_active"`
+	CallInProgress bool                `json:"call_in_progress"`
+	CDPConnected   bool                `json:"cdp_connected"`
+	BlockedURLs    []string            `json:"blocked_urls,omitempty"`
+	Restricted     []RestrictedProcess `json:"restricted,omitempty"`
+	ConfigHash     string              `json:"config_hash,omitempty"`
+	Overrides      []Override          `json:"overrides,omitempty"`
+	Errors         []StatusError       `json:"errors,omitempty"`
+	Handles        HandleStats         `json:"handles"`
+	Sessions       []SessionStatus     `json:"sessions,omitempty"`
+	UpdatedAt      time.Time           `json:"updated_at"`
+}
+
+// RestrictedProcess es un proceso restringido y la acción aplicada.
+type RestrictedProcess struct {
+	Name   string `json:"name"`
+	PID    uint32 `json:"pid"`
+	Action string `json:"action"`
+}
+
+// StatusError es un error ocurrido desde el último reporte enviado.
+type StatusError struct {
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+// SessionStatus resume una sesión supervisada en el modo multisesión.
+type SessionStatus struct {
+	Session
+	Active     bool     `json:"active"`
+	InCall     bool     `json:"in_call"`
+	Restricted []string `json:"restricted,omitempty"`
+}
+
+var (
+	agentStatus       = AgentStatus{Version: AgentVersion, Connection: ConnectionOffline}
+	agentStatusMu     sync.Mutex
+	statusTransitions = make(chan struct{}, 1)
+)
+
+// GetAgentStatus retorna una copia del estado actual del agente.
+func GetAgentStatus() AgentStatus {
+	agentStatusMu.Lock()
+	defer agentStatusMu.Unlock()
+	status := agentStatus
+	status.Errors = append([]StatusError(nil), agentStatus.Errors...)
+	return status
+}
+
+// updateAgentStatus modifica el estado del agente de forma segura. Si cambia
+// algo que el servidor debe conocer de inmediato (conexión, llamada,
+// bloqueos, configuración) se adelanta el próximo reporte.
+func updateAgentStatus(update func(*AgentStatus)) {
+	agentStatusMu.Lock()
+	defer agentStatusMu.Unlock()
+	before := transitionKey(agentStatus)
+	update(&agentStatus)
+	agentStatus.UpdatedAt = time.Now()
+	if transitionKey(agentStatus) != before {
+		select {
+		case statusTransitions <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// transitionKey resume los campos cuyo cambio dispara un reporte inmediato.
+func transitionKey(status AgentStatus) string {
+	return fmt.Sprint(status.Connection, status.UserActive, status.CallInProgress, status.CDPConnected,
+		status.BlockedURLs, status.Restricted, status.Sessions, status.ConfigHash, status.Overrides)
+}
+
+// recordError registra el error en el log y lo conserva para el próximo
+// reporte de estado.
+func recordError(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Print(message)
+	agentStatusMu.Lock()
+	defer agentStatusMu.Unlock()
+	agentStatus.Errors = append(agentStatus.Errors, StatusError{At: time.Now(), Message: strings.TrimSpace(message)})
+	if excess := len(agentStatus.Errors) - maxStatusErrors; excess > 0 {
+		agentStatus.Errors = agentStatus.Errors[excess:]
+	}
+}
+
+// clearReportedErrors descarta los errores ya enviados al servidor.
+func clearReportedErrors(reported []StatusError) {
+	agentStatusMu.Lock()
+	defer agentStatusMu.Unlock()
+	remaining := agentStatus.Errors[:0]
+	for _, statusErr := range agentStatus.Errors {
+		if !containsStatusError(reported, statusErr) {
+			remaining = append(remaining, statusErr)
+		}
+	}
+	agentStatus.Errors = remaining
+}
+
+func containsStatusError(errs []StatusError, target StatusError) bool {
+	for _, statusErr := range errs {
+		if statusErr.At.Equal(target.At) && statusErr.Message == target.Message {
+			return true
+		}
+	}
+	return false
+}
+
+// configHash identifica la configuración aplicada.
+func configHash(cfg ConfigResponse) string {
+	raw, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:8])
+}
+
+// sendStatusReport envía el estado actual por el canal de control.
+func sendStatusReport() error {
+	status := GetAgentStatus()
+	if err := SendWebSocketMessage("agent_status", status); err != nil {
+		return err
+	}
+	clearReportedErrors(status.Errors)
+	return nil
+}
+
+// ReportAgentStatus envía el estado del agente al servidor cada interval y,
+// además, de inmediato ante cada transición, hasta que se cancele ctx. Los
+// reportes que no se pueden enviar por falta de conexión se omiten; al
+// reconectar la transición a "connected" provoca uno nuevo.
+func ReportAgentStatus(ctx context.Context, user *domain.User, interval time.Duration) {
+	updateAgentStatus(func(status *AgentStatus) {
+		status.User = user.Name
+		status.Client = user.Client
+		status.ConfigHash = configHash(GetCurrentConfig())
+	})
+	unsubscribe := OnConfigChange(func(cfg ConfigResponse) {
+		updateAgentStatus(func(status *AgentStatus) {
+			status.ConfigHash = configHash(cfg)
+		})
+	})
+	defer unsubscribe()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-statusTransitions:
+		}
+		if GetConnectionState() != ConnectionConnected {
+			continue
+		}
+		if err := sendStatusReport(); err != nil {
+			log.Printf("Error enviando el estado del agente: %v\n", err)
+		}
+	}
+}
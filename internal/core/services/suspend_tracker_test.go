@@ -0,0 +1,367 @@
+package services
+
+import (
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/policy"
+)
+
+// fakeNT simula el contador de suspensión de NtSuspendProcess/NtResumeProcess:
+// cada suspensión lo incrementa, cada reanudación lo decrementa sin bajar de
+// cero y el proceso solo corre con el contador en cero.
+type fakeNT struct {
+	mu     sync.Mutex
+	counts map[uint32]int
+	fail   map[uint32]bool
+}
+
+func newFakeNT() *fakeNT {
+	return &fakeNT{counts: make(map[uint32]int), fail: make(map[uint32]bool)}
+}
+
+func (n *fakeNT) suspend(pid uint32) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.fail[pid] {
+		return errors.New("acceso denegado")
+	}
+	n.counts[pid]++
+	return nil
+}
+
+func (n *fakeNT) resume(pid uint32) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.counts[pid] > 0 {
+		n.counts[pid]--
+	}
+	return nil
+}
+
+func (n *fakeNT) count(pid uint32) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.counts[pid]
+}
+
+// fakeApplicationManager usa el suspendTracker real sobre el contador simulado.
+type fakeApplicationManager struct {
+	processSetOps
+	suspendTracker
+	noProcessHandles
+	nt    *fakeNT
+	table []ProcessInfo
+}
+
+func newFakeApplicationManager(table []ProcessInfo) *fakeApplicationManager {
+	return &fakeApplicationManager{suspendTracker: newSuspendTracker(), nt: newFakeNT(), table: table}
+}
+
+func (am *fakeApplicationManager) SuspendProcess(process ProcessInfo) error {
+	return am.suspend(process, func() error { return am.nt.suspend(process.ID) })
+}
+
+func (am *fakeApplicationManager) ResumeProcess(process ProcessInfo) error {
+	return am.resume(process, func() error { return am.nt.resume(process.ID) })
+}
+
+func (am *fakeApplicationManager) TerminateProcess(process ProcessInfo) error {
+	return errors.New("no soportado")
+}
+
+func (am *fakeApplicationManager) ListApplicationsInCurrentSession() ([]ProcessInfo, error) {
+	return am.table, nil
+}
+
+func (am *fakeApplicationManager) ListApplicationsInSession(session Session) ([]ProcessInfo, error) {
+	return am.table, nil
+}
+
+func (am *fakeApplicationManager) DescribeProcesses(processes []ProcessInfo) {}
+
+func (am *fakeApplicationManager) GetProcessesInCurrentSession(processName string) ([]ProcessInfo, error) {
+	processes := filterByName(am.table, processName)
+	if len(processes) == 0 {
+		return nil, errors.New("no encontrado")
+	}
+	return processes, nil
+}
+
+var boot = time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)
+
+// cobisTable es un cliente que lanza ayudantes; cmd.exe también se monitorea
+// por sí solo, así que queda restringido por dos grupos a la vez.
+var cobisTable = []ProcessInfo{
+	{ID: 10, Name: "explorer.exe", StartTime: boot},
+	{ID: 20, Name: "cobis.exe", ParentID: 10, StartTime: boot.Add(time.Minute)},
+	{ID: 21, Name: "javaw.exe", ParentID: 20, StartTime: boot.Add(2 * time.Minute)},
+	{ID: 22, Name: "cmd.exe", ParentID: 20, StartTime: boot.Add(2 * time.Minute)},
+	{ID: 23, Name: "CommunicationManager.exe", ParentID: 21, StartTime: boot.Add(3 * time.Minute)},
+	{ID: 30, Name: "cxc.exe", ParentID: 10, StartTime: boot.Add(time.Minute)},
+}
+
+// newTestLedger crea un registro temporal para que las pruebas no escriban
+// el registro del usuario.
+func newTestLedger(t *testing.T) *Ledger {
+	t.Helper()
+	return NewLedger(filepath.Join(t.TempDir(), "ledger.json"))
+}
+
+func TestSuspendTrackerIsIdempotent(t *testing.T) {
+	am := newFakeApplicationManager(cobisTable)
+	process := cobisTable[1]
+
+	for i := 0; i < 3; i++ {
+		if err := am.SuspendProcess(process); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := am.nt.count(process.ID); got != 1 {
+		t.Fatalf("contador NT = %d tras suspender tres veces, se esperaba 1", got)
+	}
+	if state := am.State(process.ID); !state.Tracked || !state.Suspended || state.Refs != 3 {
+		t.Fatalf("estado = %+v", state)
+	}
+
+	for i := 0; i < 3; i++ {
+		am.ResumeProcess(process)
+	}
+	if got := am.nt.count(process.ID); got != 0 {
+		t.Fatalf("contador NT = %d, el proceso quedó congelado", got)
+	}
+	if state := am.State(process.ID); state.Tracked {
+		t.Fatalf("el proceso sigue registrado: %+v", state)
+	}
+}
+
+func TestSuspendTrackerKeepsProcessSuspendedWhileReferenced(t *testing.T) {
+	am := newFakeApplicationManager(cobisTable)
+	process := cobisTable[3]
+
+	am.SuspendProcess(process)
+	am.SuspendProcess(process)
+	am.ResumeProcess(process)
+	if got := am.nt.count(process.ID); got != 1 {
+		t.Fatalf("contador NT = %d con una restricción vigente, se esperaba 1", got)
+	}
+	if state := am.State(process.ID); state.Refs != 1 || !state.Desired {
+		t.Fatalf("estado = %+v", state)
+	}
+	am.ResumeProcess(process)
+	if got := am.nt.count(process.ID); got != 0 {
+		t.Fatalf("contador NT = %d, se esperaba 0", got)
+	}
+}
+
+func TestSuspendTrackerRetriesFailedTransition(t *testing.T) {
+	am := newFakeApplicationManager(cobisTable)
+	process := cobisTable[5]
+
+	am.nt.fail[process.ID] = true
+	if err := am.SuspendProcess(process); err == nil {
+		t.Fatal("se esperaba un error")
+	}
+	// La restricción fallida no cuenta: no queda registrada.
+	if state := am.State(process.ID); state.Tracked {
+		t.Fatalf("estado tras el error = %+v", state)
+	}
+
+	am.nt.fail[process.ID] = false
+	am.SuspendProcess(process)
+	if got := am.nt.count(process.ID); got != 1 {
+		t.Fatalf("contador NT = %d, se esperaba 1", got)
+	}
+	am.ResumeProcess(process)
+	if got := am.nt.count(process.ID); got != 0 {
+		t.Fatalf("contador NT = %d, se esperaba 0", got)
+	}
+	if state := am.State(process.ID); state.Tracked {
+		t.Fatalf("el proceso sigue registrado: %+v", state)
+	}
+}
+
+func TestSuspendTrackerIgnoresReusedPID(t *testing.T) {
+	am := newFakeApplicationManager(cobisTable)
+	old := cobisTable[5]
+	reused := old
+	reused.StartTime = old.StartTime.Add(time.Hour)
+
+	am.SuspendProcess(old)
+	am.SuspendProcess(reused)
+	// El proceso nuevo necesita su propia suspensión y una sola reanudación.
+	if state := am.State(reused.ID); state.Refs != 1 || !state.Suspended {
+		t.Fatalf("el registro no se reinició para el PID reutilizado: %+v", state)
+	}
+	if got := am.nt.count(reused.ID); got != 2 {
+		t.Fatalf("contador NT = %d, se esperaba que el proceso nuevo se suspendiera", got)
+	}
+}
+
+func monitorMatcher(t *testing.T) *ProcessMatcher {
+	t.Helper()
+	matcher, warnings := NewProcessMatcher([]domain.ProcessSelector{
+		{Name: "cobis.exe", SuspendTree: true},
+		{Name: "cmd.exe"},
+		{Name: "cxc.exe"},
+	})
+	if len(warnings) > 0 {
+		t.Fatalf("avisos inesperados: %+v", warnings)
+	}
+	return matcher
+}
+
+func decision(verdicts map[string]policy.Verdict) policy.Decision {
+	return policy.Decision{Processes: verdicts, URLs: map[string]policy.Verdict{}}
+}
+
+func assertNoneStuck(t *testing.T, am *fakeApplicationManager) {
+	t.Helper()
+	for _, process := range am.table {
+		if got := am.nt.count(process.ID); got != 0 {
+			t.Errorf("%s (PID %d) quedó congelado con contador NT %d", process.Name, process.ID, got)
+		}
+		if state := am.State(process.ID); state.Tracked {
+			t.Errorf("%s (PID %d) sigue registrado: %+v", process.Name, process.ID, state)
+		}
+	}
+}
+
+func TestMonitorReapplyAndOverlappingTreesNeverLeaveProcessStuck(t *testing.T) {
+	ledger := newTestLedger(t)
+	am := newFakeApplicationManager(cobisTable)
+	enforcers := newEnforcerSet(am, ledger)
+	registry := newEnforcementRegistry(ledger)
+	matcher := monitorMatcher(t)
+	matching := matcher.Match(am.table)
+
+	suspendAll := decision(map[string]policy.Verdict{
+		"cobis.exe": policy.VerdictSuspend,
+		"cmd.exe":   policy.VerdictSuspend,
+		"cxc.exe":   policy.VerdictSuspend,
+	})
+	// El monitor reaplica el veredicto cuando cambian las instancias.
+	for i := 0; i < 3; i++ {
+		applyProcessVerdicts(am, enforcers, registry, suspendAll, matching, am.table, matcher)
+	}
+	for _, process := range am.table[1:] {
+		if got := am.nt.count(process.ID); got != 1 {
+			t.Fatalf("%s: contador NT = %d, se esperaba 1", process.Name, got)
+		}
+	}
+	if state := am.State(22); state.Refs != 2 {
+		t.Fatalf("cmd.exe debería estar restringido por dos grupos: %+v", state)
+	}
+
+	// Liberar solo el árbol de cobis.exe no debe reanudar cmd.exe.
+	applyProcessVerdicts(am, enforcers, registry, decision(map[string]policy.Verdict{"cobis.exe": policy.VerdictResume}), matching, am.table, matcher)
+	if got := am.nt.count(22); got != 1 {
+		t.Fatalf("cmd.exe se reanudó aunque sigue restringido: contador %d", got)
+	}
+	if got := am.nt.count(21); got != 0 {
+		t.Fatalf("javaw.exe quedó congelado: contador %d", got)
+	}
+
+	applyProcessVerdicts(am, enforcers, registry, decision(map[string]policy.Verdict{
+		"cmd.exe": policy.VerdictResume,
+		"cxc.exe": policy.VerdictResume,
+	}), matching, am.table, matcher)
+	assertNoneStuck(t, am)
+	if entries := ledger.Entries(); len(entries) != 0 {
+		t.Errorf("el registro persistente conserva %d procesos", len(entries))
+	}
+}
+
+func TestMonitorRandomVerdictSequencesNeverLeaveProcessStuck(t *testing.T) {
+	ledger := newTestLedger(t)
+	names := []string{"cobis.exe", "cmd.exe", "cxc.exe", "javaw.exe"}
+	verdicts := []policy.Verdict{policy.VerdictSuspend, policy.VerdictResume}
+
+	for seed := int64(1); seed <= 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		am := newFakeApplicationManager(cobisTable)
+		enforcers := newEnforcerSet(am, ledger)
+		registry := newEnforcementRegistry(ledger)
+		matcher := monitorMatcher(t)
+		matching := matcher.Match(am.table)
+
+		for cycle := 0; cycle < 60; cycle++ {
+			changes := map[string]policy.Verdict{}
+			for _, name := range names {
+				if rng.Intn(3) > 0 {
+					changes[name] = verdicts[rng.Intn(len(verdicts))]
+				}
+			}
+			applyProcessVerdicts(am, enforcers, registry, decision(changes), matching, am.table, matcher)
+			for _, process := range am.table {
+				if got := am.nt.count(process.ID); got > 1 {
+					t.Fatalf("semilla %d, ciclo %d: %s suspendido %d veces", seed, cycle, process.Name, got)
+				}
+			}
+		}
+
+		// Al terminar (o al detener el agente) se libera todo.
+		release := map[string]policy.Verdict{}
+		for _, name := range names {
+			release[name] = policy.VerdictResume
+		}
+		applyProcessVerdicts(am, enforcers, registry, decision(release), matching, am.table, matcher)
+		releaseGroups(registry, registry.takeAll())
+		assertNoneStuck(t, am)
+	}
+}
+
+func TestMonitorRetriesProcessWhoseSuspensionFailed(t *testing.T) {
+	ledger := newTestLedger(t)
+	am := newFakeApplicationManager(cobisTable)
+	enforcers := newEnforcerSet(am, ledger)
+	registry := newEnforcementRegistry(ledger)
+	matcher := monitorMatcher(t)
+	matching := matcher.Match(am.table)
+	suspend := decision(map[string]policy.Verdict{"cobis.exe": policy.VerdictSuspend, "cxc.exe": policy.VerdictSuspend})
+
+	// La primera suspensión de javaw.exe (descendiente de cobis.exe) y la de
+	// cxc.exe fallan una vez.
+	am.nt.fail[21] = true
+	am.nt.fail[30] = true
+	if failed := applyProcessVerdicts(am, enforcers, registry, suspend, matching, am.table, matcher); !failed {
+		t.Fatal("se esperaba que el ciclo informara el fallo")
+	}
+	for _, pid := range []uint32{21, 30} {
+		if got := am.nt.count(pid); got != 0 {
+			t.Fatalf("PID %d: contador NT = %d tras el fallo, se esperaba 0", pid, got)
+		}
+		if state := am.State(pid); state.Tracked {
+			t.Fatalf("PID %d sigue registrado tras el fallo: %+v", pid, state)
+		}
+	}
+	if got := am.nt.count(22); got != 1 {
+		t.Fatalf("cmd.exe: contador NT = %d, el resto del árbol debía restringirse", got)
+	}
+
+	// El monitor reaplica el mismo veredicto en el ciclo siguiente.
+	am.nt.fail[21] = false
+	am.nt.fail[30] = false
+	if failed := applyProcessVerdicts(am, enforcers, registry, suspend, matching, am.table, matcher); failed {
+		t.Fatal("el reintento no debía fallar")
+	}
+	for _, process := range am.table[1:] {
+		if got := am.nt.count(process.ID); got != 1 {
+			t.Fatalf("%s: contador NT = %d tras el reintento, se esperaba 1", process.Name, got)
+		}
+	}
+
+	applyProcessVerdicts(am, enforcers, registry, decision(map[string]policy.Verdict{
+		"cobis.exe": policy.VerdictResume,
+		"cxc.exe":   policy.VerdictResume,
+	}), matching, am.table, matcher)
+	assertNoneStuck(t, am)
+	if entries := ledger.Entries(); len(entries) != 0 {
+		t.Errorf("el registro persistente conserva %d procesos", len(entries))
+	}
+}
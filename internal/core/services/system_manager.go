@@ -1,55 +1,23 @@
 package services
 
-import (
-	"golang.org/x/sys/windows"
-)
+import "fmt"
 
 type SystemManager interface {
 	EnableDebugPrivilege() error
 	GetCurrentSessionID() (uint32, error)
+	ListInteractiveSessions() ([]Session, error)
 }
 
-type windowsSystemManager struct{}
-
-func NewWindowsSystemManager() SystemManager {
-	return &windowsSystemManager{}
-}
-
-func (s *windowsSystemManager) EnableDebugPrivilege() error {
-	var hToken windows.Token
-	err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &hToken)
-	if err != nil {
-		return err
-	}
-	defer hToken.Close()
-
-	var tkp windows.Tokenprivileges
-	tkp.PrivilegeCount = 1
-	tkp.Privileges[0].Attributes = windows.SE_PRIVILEGE_ENABLED
-
-	name, err := windows.UTF16PtrFromString("SeDebugPrivilege")
-	if err != nil {
-		return err
-	}
-	err = windows.LookupPrivilegeValue(nil, name, &tkp.Privileges[0].Luid)
-	if err != nil {
-		return err
-	}
-
-	err = windows.AdjustTokenPrivileges(hToken, false, &tkp, 0, nil, nil)
-	if err != nil {
-		return err
-	}
-
-	return nil
+// Session es una sesión interactiva del equipo y el usuario que la abrió.
+// ID es la sesión de Windows o la sesión de logind en Linux (0 si logind no
+// usa un identificador numérico); UID solo se informa en Linux.
+type Session struct {
+	ID   uint32 `json:"id"`
+	User string `json:"user"`
+	UID  uint32 `json:"uid,omitempty"`
 }
 
-func (s *windowsSystemManager) GetCurrentSessionID() (uint32, error) {
-	var sessionID uint32
-	processID := windows.GetCurrentProcessId()
-	err := windows.ProcessIdToSessionId(processID, &sessionID)
-	if err != nil {
-		return 0, err
-	}
-	return sessionID, nil
+// Key identifica la sesión entre ciclos del monitor.
+func (s Session) Key() string {
+	return fmt.Sprintf("%d/%s", s.ID, s.User)
 }
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	logindSessionsDir = "/run/systemd/sessions"
+	utmpPath          = "/var/run/utmp"
+	// utmpRecordSize y los desplazamientos corresponden a struct utmp de glibc
+	// en arquitecturas de 64 bits.
+	utmpRecordSize    = 384
+	utmpUserProcess   = 7
+	utmpUserOffset    = 44
+	utmpUserSize      = 32
+	utmpSessionOffset = 336
+)
+
+type linuxSystemManager struct{}
+
+func NewLinuxSystemManager() SystemManager {
+	return &linuxSystemManager{}
+}
+
+// NewSystemManager crea el SystemManager de la plataforma actual.
+func NewSystemManager() SystemManager {
+	return NewLinuxSystemManager()
+}
+
+// EnableDebugPrivilege no tiene equivalente en Linux: enviar señales a los
+// procesos del mismo usuario no requiere privilegios adicionales.
+func (s *linuxSystemManager) EnableDebugPrivilege() error {
+	return nil
+}
+
+// GetCurrentSessionID retorna la sesión de login (audit) del proceso actual.
+func (s *linuxSystemManager) GetCurrentSessionID() (uint32, error) {
+	raw, err := os.ReadFile("/proc/self/sessionid")
+	if err != nil {
+		return 0, err
+	}
+	sessionID, err := strconv.ParseUint(strings.TrimSpace(string(raw)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(sessionID), nil
+}
+
+// ListInteractiveSessions enumera las sesiones de usuario de logind. Si
+// logind no está disponible se leen las entradas USER_PROCESS de utmp. En
+// ambos casos las sesiones del mismo usuario se agrupan en una, ya que los
+// procesos se asocian a la sesión por UID.
+func (s *linuxSystemManager) ListInteractiveSessions() ([]Session, error) {
+	sessions, err := logindSessions(logindSessionsDir)
+	if err != nil || len(sessions) == 0 {
+		sessions, err = utmpSessions(utmpPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return uniqueSessionsByUser(sessions), nil
+}
+
+// logindSessions lee los archivos de estado que logind mantiene por sesión.
+// Se omiten las sesiones de greeter y las que están cerrándose.
+func logindSessions(dir string) ([]Session, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		values, err := readKeyValueFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if values["CLASS"] != "user" || values["STATE"] == "closing" || values["USER"] == "" {
+			continue
+		}
+		uid, err := strconv.ParseUint(values["UID"], 10, 32)
+		if err != nil {
+			continue
+		}
+		sessionID, _ := strconv.ParseUint(entry.Name(), 10, 32)
+		sessions = append(sessions, Session{ID: uint32(sessionID), User: values["USER"], UID: uint32(uid)})
+	}
+	return sessions, nil
+}
+
+// utmpSessions lee los registros USER_PROCESS de utmp y resuelve el UID de
+// cada usuario.
+func utmpSessions(path string) ([]Session, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for offset := 0; offset+utmpRecordSize <= len(raw); offset += utmpRecordSize {
+		record := raw[offset : offset+utmpRecordSize]
+		if binary.LittleEndian.Uint16(record[0:2]) != utmpUserProcess {
+			continue
+		}
+		name := string(bytes.TrimRight(record[utmpUserOffset:utmpUserOffset+utmpUserSize], "\x00"))
+		account, err := user.Lookup(name)
+		if err != nil {
+			continue
+		}
+		uid, err := strconv.ParseUint(account.Uid, 10, 32)
+		if err != nil {
+			continue
+		}
+		sessionID := binary.LittleEndian.Uint32(record[utmpSessionOffset : utmpSessionOffset+4])
+		sessions = append(sessions, Session{ID: sessionID, User: name, UID: uint32(uid)})
+	}
+	return sessions, nil
+}
+
+// uniqueSessionsByUser conserva la primera sesión de cada UID.
+func uniqueSessionsByUser(sessions []Session) []Session {
+	seen := make(map[uint32]bool)
+	var unique []Session
+	for _, session := range sessions {
+		if seen[session.UID] {
+			continue
+		}
+		seen[session.UID] = true
+		unique = append(unique, session)
+	}
+	return unique
+}
+
+// readKeyValueFile interpreta un archivo de líneas CLAVE=valor.
+func readKeyValueFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			values[key] = value
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	wtsapi32                        = windows.NewLazySystemDLL("wtsapi32.dll")
+	procWTSQuerySessionInformationW = wtsapi32.NewProc("WTSQuerySessionInformationW")
+)
+
+// wtsUserName es la clase WTSUserName de WTS_INFO_CLASS.
+const wtsUserName = 5
+
+type windowsSystemManager struct{}
+
+func NewWindowsSystemManager() SystemManager {
+	return &windowsSystemManager{}
+}
+
+// NewSystemManager crea el SystemManager de la plataforma actual.
+func NewSystemManager() SystemManager {
+	return NewWindowsSystemManager()
+}
+
+func (s *windowsSystemManager) EnableDebugPrivilege() error {
+	var hToken windows.Token
+	err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &hToken)
+	if err != nil {
+		return err
+	}
+	defer hToken.Close()
+
+	var tkp windows.Tokenprivileges
+	tkp.PrivilegeCount = 1
+	tkp.Privileges[0].Attributes = windows.SE_PRIVILEGE_ENABLED
+
+	name, err := windows.UTF16PtrFromString("SeDebugPrivilege")
+	if err != nil {
+		return err
+	}
+	err = windows.LookupPrivilegeValue(nil, name, &tkp.Privileges[0].Luid)
+	if err != nil {
+		return err
+	}
+
+	err = windows.AdjustTokenPrivileges(hToken, false, &tkp, 0, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *windowsSystemManager) GetCurrentSessionID() (uint32, error) {
+	var sessionID uint32
+	processID := windows.GetCurrentProcessId()
+	err := windows.ProcessIdToSessionId(processID, &sessionID)
+	if err != nil {
+		return 0, err
+	}
+	return sessionID, nil
+}
+
+// ListInteractiveSessions enumera con WTS las sesiones conectadas o
+// desconectadas que tienen un usuario. Se omite la sesión 0 de servicios.
+func (s *windowsSystemManager) ListInteractiveSessions() ([]Session, error) {
+	var infos *windows.WTS_SESSION_INFO
+	var count uint32
+	if err := windows.WTSEnumerateSessions(0, 0, 1, &infos, &count); err != nil {
+		return nil, fmt.Errorf("error enumerando las sesiones: %v", err)
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(infos)))
+
+	var sessions []Session
+	for _, info := range unsafe.Slice(infos, count) {
+		if info.SessionID == 0 || (info.State != windows.WTSActive && info.State != windows.WTSDisconnected) {
+			continue
+		}
+		user, err := sessionUserName(info.SessionID)
+		if err != nil || user == "" {
+			continue
+		}
+		sessions = append(sessions, Session{ID: info.SessionID, User: user})
+	}
+	return sessions, nil
+}
+
+// sessionUserName retorna el usuario (sin dominio) que abrió la sesión.
+func sessionUserName(sessionID uint32) (string, error) {
+	var buffer *uint16
+	var size uint32
+	ret, _, err := procWTSQuerySessionInformationW.Call(0, uintptr(sessionID), wtsUserName,
+		uintptr(unsafe.Pointer(&buffer)), uintptr(unsafe.Pointer(&size)))
+	if ret == 0 {
+		return "", err
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(buffer)))
+	return windows.UTF16PtrToString(buffer), nil
+}
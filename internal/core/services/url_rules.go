@@ -0,0 +1,224 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// URLRule es una entrada de UrlsToBlock normalizada a un nombre de host.
+// Las entradas "*.dominio" son comodines que cubren el dominio y sus
+// subdominios. Path conserva la ruta de la entrada: solo el backend proxy la
+// distingue; los backends hosts y DNS bloquean el host completo.
+type URLRule struct {
+	Raw      string `json:"raw"`
+	Host     string `json:"host"`
+	Wildcard bool   `json:"wildcard"`
+	Path     string `json:"path,omitempty"`
+}
+
+// RejectedURL es una entrada de UrlsToBlock que no se pudo interpretar.
+type RejectedURL struct {
+	Entry  string `json:"entry"`
+	Reason string `json:"reason"`
+}
+
+// DefaultSubdomains son los subdominios que se expanden para los comodines
+// cuando el servidor no entrega una lista propia.
+func DefaultSubdomains() []string {
+	return []string{"www", "m", "mobile", "web", "api", "static", "cdn", "login", "accounts"}
+}
+
+// subdomainsFor retorna los subdominios configurados o los por defecto.
+func subdomainsFor(cfg ConfigResponse) []string {
+	if len(cfg.Subdomains) == 0 {
+		return DefaultSubdomains()
+	}
+	return cfg.Subdomains
+}
+
+// ParseURLRule normaliza una entrada: quita esquema, credenciales, consulta
+// y puerto, separa la ruta, pasa el host a minúsculas y lo valida.
+func ParseURLRule(entry string) (URLRule, error) {
+	rule := URLRule{Raw: entry}
+	value := strings.TrimSpace(entry)
+	if value == "" {
+		return rule, fmt.Errorf("entrada vacía")
+	}
+
+	if strings.Contains(value, "://") {
+		parsed, err := url.Parse(value)
+		if err != nil {
+			return rule, fmt.Errorf("URL inválida: %v", err)
+		}
+		value = strings.ToLower(parsed.Host)
+		rule.Path = parsed.Path
+	} else {
+		if i := strings.IndexAny(value, "?#"); i >= 0 {
+			value = value[:i]
+		}
+		if i := strings.Index(value, "/"); i >= 0 {
+			rule.Path = value[i:]
+			value = value[:i]
+		}
+		value = strings.ToLower(value)
+		if i := strings.LastIndex(value, "@"); i >= 0 {
+			value = value[i+1:]
+		}
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+
+	if strings.HasPrefix(value, "*.") {
+		rule.Wildcard = true
+		value = value[2:]
+	}
+	value = strings.TrimSuffix(value, ".")
+	if rule.Path == "/" {
+		rule.Path = ""
+	}
+
+	if err := validateHostname(value); err != nil {
+		return rule, err
+	}
+	rule.Host = value
+	return rule, nil
+}
+
+// validateHostname verifica que el valor sea un nombre de dominio que se
+// pueda escribir en el archivo hosts.
+func validateHostname(host string) error {
+	if host == "" {
+		return fmt.Errorf("no contiene un nombre de host")
+	}
+	if net.ParseIP(host) != nil {
+		return fmt.Errorf("las direcciones IP no se pueden bloquear por nombre")
+	}
+	if len(host) > 253 {
+		return fmt.Errorf("el nombre de host supera los 253 caracteres")
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%q no es un dominio completo", host)
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("%q tiene una etiqueta vacía o demasiado larga", host)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("%q tiene una etiqueta que empieza o termina en guion", host)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("%q contiene el carácter inválido %q", host, c)
+			}
+		}
+	}
+	return nil
+}
+
+// ParseURLRules normaliza todas las entradas y separa las rechazadas.
+func ParseURLRules(entries []string) ([]URLRule, []RejectedURL) {
+	var rules []URLRule
+	var rejected []RejectedURL
+	for _, entry := range entries {
+		rule, err := ParseURLRule(entry)
+		if err != nil {
+			rejected = append(rejected, RejectedURL{Entry: entry, Reason: err.Error()})
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rejected
+}
+
+// Hosts retorna los nombres que deben bloquearse para la regla. Un comodín
+// se expande al dominio y a cada uno de los subdominios indicados.
+func (r URLRule) Hosts(subdomains []string) []string {
+	hosts := []string{r.Host}
+	if r.Wildcard {
+		for _, sub := range subdomains {
+			hosts = append(hosts, sub+"."+r.Host)
+		}
+	}
+	return hosts
+}
+
+// MatchesHost indica si el host (p. ej. el de una pestaña) está cubierto por la regla.
+func (r URLRule) MatchesHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return host == r.Host || (r.Wildcard && strings.HasSuffix(host, "."+r.Host))
+}
+
+// MatchesURL indica si la URL está cubierta por la regla, incluida la ruta.
+func (r URLRule) MatchesURL(u *url.URL) bool {
+	if !r.MatchesHost(u.Hostname()) {
+		return false
+	}
+	return r.Path == "" || strings.HasPrefix(u.Path, r.Path)
+}
+
+// ExpandURLRules retorna el conjunto de hosts a bloquear para las reglas.
+func ExpandURLRules(rules []URLRule, subdomains []string) []string {
+	var hosts []string
+	for _, rule := range rules {
+		hosts = append(hosts, rule.Hosts(subdomains)...)
+	}
+	return normalizeHostSet(hosts)
+}
+
+// validURLEntries retorna solo las entradas que se pueden interpretar.
+func validURLEntries(entries []string) []string {
+	rules, _ := ParseURLRules(entries)
+	valid := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		valid = append(valid, rule.Raw)
+	}
+	return valid
+}
+
+// degradedURLs retorna las entradas con ruta que el backend configurado no
+// puede aplicar tal como están escritas: los backends hosts y DNS bloquean el
+// host completo y el proxy solo ve la ruta en HTTP, no en los túneles HTTPS.
+func degradedURLs(cfg ConfigResponse) []RejectedURL {
+	rules, _ := ParseURLRules(cfg.UrlsToBlock)
+	var degraded []RejectedURL
+	for _, rule := range rules {
+		if rule.Path == "" {
+			continue
+		}
+		reason := fmt.Sprintf("la ruta %s se ignora: se bloquea todo %s", rule.Path, rule.Host)
+		if cfg.BlockingBackend == BlockingBackendProxy {
+			reason = fmt.Sprintf("la ruta %s solo se bloquea en HTTP; por HTTPS %s queda permitido", rule.Path, rule.Host)
+		}
+		degraded = append(degraded, RejectedURL{Entry: rule.Raw, Reason: reason})
+	}
+	return degraded
+}
+
+// ReportRejectedURLs informa al servidor las entradas de UrlsToBlock que se
+// descartaron por no ser válidas y las que el backend aplica solo en parte.
+func ReportRejectedURLs(cfg ConfigResponse, client string) {
+	_, rejected := ParseURLRules(cfg.UrlsToBlock)
+	degraded := degradedURLs(cfg)
+	if len(rejected) == 0 && len(degraded) == 0 {
+		return
+	}
+	for _, r := range rejected {
+		log.Printf("URL descartada %q: %s\n", r.Entry, r.Reason)
+	}
+	for _, r := range degraded {
+		log.Printf("URL aplicada en parte %q: %s\n", r.Entry, r.Reason)
+	}
+	report := map[string]interface{}{
+		"client":   client,
+		"rejected": rejected,
+		"degraded": degraded,
+	}
+	if err := SendWebSocketMessage("urls_rejected", report); err != nil {
+		log.Printf("Error reportando las URLs descartadas: %v\n", err)
+	}
+}
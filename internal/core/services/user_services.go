@@ -6,6 +6,7 @@ import (
 	"log"
 	"os/user"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
@@ -32,74 +33,63 @@ func GetUser() (domain.User, error) {
 	return user, nil
 }
 
-func ConnectAndKeepOpen(wsURL string, user *domain.User) error {
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+var (
+	wsConn  *websocket.Conn
+	wsMutex sync.Mutex
+)
+
+// SendWebSocketMessage envía un mensaje al servidor por la conexión WebSocket
+// activa, dentro de un Envelope con data como payload.
+func SendWebSocketMessage(msgType string, data interface{}) error {
+	payload, err := json.Marshal(data)
 	if err != nil {
-		log.Printf("Error al conectar con el WebSocket: %v\n", err)
-		return fmt.Errorf("error al conectar con el WebSocket: %w", err)
+		return fmt.Errorf("error serializando el mensaje %s: %w", msgType, err)
+	}
+	message := Envelope{
+		ID:        newMessageID(),
+		Type:      msgType,
+		Version:   ProtocolVersion,
+		Payload:   payload,
+		Timestamp: time.Now(),
 	}
-	defer conn.Close()
-
-	log.Println("Conexión establecida con el WebSocket")
-
-	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("Error al leer mensaje del servidor: %v\n", err)
-			break
-		}
 
-		log.Printf("Mensaje recibido del servidor: %s\n", message)
+	wsMutex.Lock()
+	defer wsMutex.Unlock()
 
-		err = handleWebSocketMessage(message, user)
-		if err != nil {
-			log.Printf("Error procesando el mensaje del WebSocket: %v\n", err)
-		}
+	if wsConn == nil {
+		return fmt.Errorf("no hay conexión WebSocket activa")
+	}
+	wsConn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := wsConn.WriteJSON(message); err != nil {
+		return fmt.Errorf("error enviando el mensaje %s: %w", msgType, err)
 	}
-
-	log.Println("Conexión cerrada")
 	return nil
 }
 
-func handleWebSocketMessage(message []byte, user *domain.User) error {
-	// Definimos la estructura del mensaje WS, incluyendo un campo opcional Data
-	type WSMessage struct {
-		Type        string        `json:"type"`
-		ActiveUsers []domain.User `json:"active_users,omitempty"`
-		// No incluimos Data si usamos la señal para hacer el fetch, pero podrías incluirlo si lo deseas:
-		// Data json.RawMessage `json:"data,omitempty"`
-	}
-
-	var wsMessage WSMessage
-	if err := json.Unmarshal(message, &wsMessage); err != nil {
-		return fmt.Errorf("error deserializando el mensaje WebSocket: %v", err)
-	}
+// ReportAgentStopped informa al servidor que el agente se detuvo y ya liberó
+// todos los bloqueos.
+func ReportAgentStopped(user *domain.User, reason string) error {
+	return SendWebSocketMessage("agent_stopped", map[string]interface{}{
+		"client":     user.Client,
+		"user":       user.Name,
+		"reason":     reason,
+		"stopped_at": time.Now(),
+	})
+}
 
-	switch wsMessage.Type {
-	case "update":
-		// Procesamos el mensaje de actualización de usuarios
-		for _, activeUser := range wsMessage.ActiveUsers {
-			if activeUser.Name == user.Name {
-				user.Active = activeUser.Active
-				log.Printf("Estado del usuario '%s' actualizado a: %v\n", user.Name, user.Active)
-				break
-			}
-		}
-	case "refresh", "configuracion":
-		// Mensaje que indica que se debe refrescar la configuración
-		log.Printf("Mensaje de actualización de configuración recibido: %s\n", wsMessage.Type)
-		// Se asume que existe la función FetchConfiguration que hace el fetch vía HTTP
-		config, err := FetchConfiguration(user.Client)
-		if err != nil {
-			log.Printf("Error al obtener nueva configuración: %v", err)
-		} else {
-			// Se actualiza el store global usando sync.Mutex (definido en configstore)
-			SetCurrentConfig(config)
-			log.Printf("Nueva configuración actualizada: %+v", config)
-		}
-	default:
-		log.Printf("Tipo de mensaje desconocido: %s", wsMessage.Type)
+// refreshConfiguration obtiene la configuración vía HTTP, actualiza el store
+// global e informa al servidor las entradas descartadas.
+func refreshConfiguration(user *domain.User) error {
+	config, err := FetchConfiguration(user.Client)
+	if err != nil {
+		log.Printf("Error al obtener nueva configuración: %v", err)
+		return err
 	}
-
+	// Se actualiza el store global usando sync.Mutex (definido en configstore)
+	SetCurrentConfig(config)
+	log.Printf("Nueva configuración actualizada: %+v", config)
+	ReportRejectedURLs(config, user.Client)
+	ReportProcessSelectorWarnings(config, user.Client)
+	ReportRejectedDetectionRules(config, user.Client)
 	return nil
 }
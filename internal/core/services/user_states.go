@@ -0,0 +1,45 @@
+package services
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// userStates guarda el último estado que el servidor informó de cada
+// usuario. Lo usa el modo multisesión para decidir por sesión.
+var (
+	userStates   = make(map[string]domain.User)
+	userStatesMu sync.Mutex
+)
+
+// userStateKey normaliza el nombre quitando el dominio y las mayúsculas,
+// igual que GetUser.
+func userStateKey(name string) string {
+	parts := strings.Split(name, "\\")
+	return strings.ToLower(parts[len(parts)-1])
+}
+
+// UpdateUserStates registra el estado de los usuarios recibidos. Los que no
+// vienen en la lista conservan su último estado, y si un usuario llega sin
+// estado de llamada se conserva el último informado.
+func UpdateUserStates(users []domain.User) {
+	userStatesMu.Lock()
+	defer userStatesMu.Unlock()
+	for _, user := range users {
+		key := userStateKey(user.Name)
+		if user.InCall == nil {
+			user.InCall = userStates[key].InCall
+		}
+		userStates[key] = user
+	}
+}
+
+// GetUserState retorna el último estado informado del usuario.
+func GetUserState(name string) (domain.User, bool) {
+	userStatesMu.Lock()
+	defer userStatesMu.Unlock()
+	user, ok := userStates[userStateKey(name)]
+	return user, ok
+}
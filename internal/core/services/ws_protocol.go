@@ -0,0 +1,297 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+)
+
+// ProtocolVersion es la versión del sobre de mensajes que entiende el agente.
+const ProtocolVersion = 1
+
+// Tipos de mensaje del canal de control.
+const (
+	MessageUpdate        = "update"
+	MessageRefresh       = "refresh"
+	MessageConfiguracion = "configuracion"
+	MessageStatus        = "status"
+	MessageUserState     = "user_state"
+	MessageHello         = "hello"
+	MessageAck           = "ack"
+	MessageNack          = "nack"
+)
+
+// Envelope es el sobre de todos los mensajes del canal de control, en ambos
+// sentidos. Payload se interpreta según Type. Los mensajes del formato
+// anterior (sin versión ni payload, con los datos en la raíz) se aceptan como
+// versión 0 y su payload es el mensaje completo.
+type Envelope struct {
+	ID        string          `json:"id,omitempty"`
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// UpdatePayload informa el estado de los usuarios.
+type UpdatePayload struct {
+	ActiveUsers []domain.User `json:"active_users"`
+}
+
+// UserStatePayload es el estado de un único usuario, enviado solo a su
+// agente por la conexión de ese usuario y cliente. Name puede omitirse; en el
+// modo multisesión indica a cuál de los usuarios del equipo corresponde.
+// InCall es el estado de llamada que conoce el servidor; el modo multisesión
+// lo necesita porque no puede detectarlo en el Chrome de cada sesión.
+type UserStatePayload struct {
+	Name   string `json:"name,omitempty"`
+	Active *bool  `json:"active"`
+	InCall *bool  `json:"in_call,omitempty"`
+}
+
+// HelloPayload se envía al abrir cada conexión para que el servidor sepa qué
+// mensajes entiende el agente (p. ej. si puede recibir user_state en lugar
+// del update con todos los usuarios).
+type HelloPayload struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	Client          string   `json:"client"`
+	User            string   `json:"user"`
+	MessageTypes    []string `json:"message_types"`
+}
+
+// RefreshPayload pide volver a obtener la configuración ("refresh" y "configuracion").
+type RefreshPayload struct{}
+
+// StatusPayload pide el estado actual del agente.
+type StatusPayload struct{}
+
+// AckPayload responde a un mensaje con ID: "ack" si se aplicó y "nack" con
+// el error si no.
+type AckPayload struct {
+	MessageID string `json:"message_id"`
+	Type      string `json:"type"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MessageHandler aplica un mensaje recibido. El error que retorne se envía
+// al servidor en el nack.
+type MessageHandler func(envelope Envelope, user *domain.User) error
+
+var (
+	messageHandlers   = make(map[string]MessageHandler)
+	messageHandlersMu sync.RWMutex
+)
+
+// RegisterMessageHandler asocia un tipo de mensaje a su handler,
+// reemplazando el anterior si existía.
+func RegisterMessageHandler(msgType string, handler MessageHandler) {
+	messageHandlersMu.Lock()
+	defer messageHandlersMu.Unlock()
+	messageHandlers[msgType] = handler
+}
+
+func messageHandlerFor(msgType string) (MessageHandler, bool) {
+	messageHandlersMu.RLock()
+	defer messageHandlersMu.RUnlock()
+	handler, ok := messageHandlers[msgType]
+	return handler, ok
+}
+
+// supportedMessageTypes retorna los tipos de mensaje con handler registrado.
+func supportedMessageTypes() []string {
+	messageHandlersMu.RLock()
+	defer messageHandlersMu.RUnlock()
+	types := make([]string, 0, len(messageHandlers))
+	for msgType := range messageHandlers {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// sendHello anuncia al servidor la versión de protocolo y los mensajes que
+// entiende el agente.
+func sendHello(user *domain.User) error {
+	return SendWebSocketMessage(MessageHello, HelloPayload{
+		ProtocolVersion: ProtocolVersion,
+		Client:          user.Client,
+		User:            user.Name,
+		MessageTypes:    supportedMessageTypes(),
+	})
+}
+
+// newMessageID genera un identificador aleatorio para un mensaje saliente.
+func newMessageID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
+
+// decodeEnvelope interpreta un mensaje recibido en el formato actual o en
+// el anterior.
+func decodeEnvelope(message []byte) (Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(message, &envelope); err != nil {
+		return envelope, fmt.Errorf("error deserializando el mensaje WebSocket: %v", err)
+	}
+	if envelope.Type == "" {
+		return envelope, fmt.Errorf("el mensaje no indica su tipo")
+	}
+	if envelope.Version == 0 && len(envelope.Payload) == 0 {
+		envelope.Payload = json.RawMessage(message)
+	}
+	return envelope, nil
+}
+
+// decodePayload interpreta el payload del sobre en v. Un payload vacío deja
+// v con sus valores por defecto.
+func decodePayload(envelope Envelope, v interface{}) error {
+	if len(envelope.Payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(envelope.Payload, v); err != nil {
+		return fmt.Errorf("payload inválido para %s: %v", envelope.Type, err)
+	}
+	return nil
+}
+
+// messageQueueSize acota los mensajes recibidos pendientes de procesar.
+const messageQueueSize = 64
+
+// processMessages ejecuta en orden los handlers de los mensajes encolados
+// hasta que se cierre queue. Corre fuera del bucle de lectura para que un
+// handler lento (restore_tabs, refresh) no detenga la lectura ni los pongs.
+func processMessages(queue <-chan Envelope, user *domain.User) {
+	for envelope := range queue {
+		if err := handleEnvelope(envelope, user); err != nil {
+			log.Printf("Error procesando el mensaje %s: %v\n", envelope.Type, err)
+		}
+	}
+}
+
+// enqueueEnvelope encola el mensaje para el worker. Si la cola está llena
+// se descarta y se responde con nack para que el servidor lo reintente.
+func enqueueEnvelope(queue chan<- Envelope, envelope Envelope) {
+	select {
+	case queue <- envelope:
+	default:
+		err := fmt.Errorf("demasiados mensajes pendientes, se descarta %s", envelope.Type)
+		log.Printf("Error encolando el mensaje del WebSocket: %v\n", err)
+		replyToEnvelope(envelope, err)
+	}
+}
+
+// handleEnvelope despacha el sobre a su handler y, si el mensaje trae ID,
+// responde con ack o nack.
+func handleEnvelope(envelope Envelope, user *domain.User) error {
+	err := dispatchEnvelope(envelope, user)
+	replyToEnvelope(envelope, err)
+	return err
+}
+
+// replyToEnvelope responde ack, o nack con el error, a un mensaje con ID.
+func replyToEnvelope(envelope Envelope, err error) {
+	if envelope.ID == "" {
+		return
+	}
+	reply := AckPayload{MessageID: envelope.ID, Type: envelope.Type}
+	replyType := MessageAck
+	if err != nil {
+		reply.Error = err.Error()
+		replyType = MessageNack
+	}
+	if sendErr := SendWebSocketMessage(replyType, reply); sendErr != nil {
+		log.Printf("Error respondiendo el mensaje %s: %v\n", envelope.ID, sendErr)
+	}
+}
+
+func dispatchEnvelope(envelope Envelope, user *domain.User) error {
+	if envelope.Version > ProtocolVersion {
+		return fmt.Errorf("versión de protocolo %d no soportada (máxima %d)", envelope.Version, ProtocolVersion)
+	}
+	handler, ok := messageHandlerFor(envelope.Type)
+	if !ok {
+		return fmt.Errorf("tipo de mensaje desconocido: %s", envelope.Type)
+	}
+	return handler(envelope, user)
+}
+
+func init() {
+	RegisterMessageHandler(MessageUpdate, handleUpdate)
+	RegisterMessageHandler(MessageUserState, handleUserState)
+	RegisterMessageHandler(MessageRefresh, handleRefresh)
+	RegisterMessageHandler(MessageConfiguracion, handleRefresh)
+	RegisterMessageHandler(MessageStatus, handleStatus)
+}
+
+// handleUpdate registra el estado de los usuarios y actualiza el del agente.
+// Es la forma anterior, difundida con todos los usuarios del cliente; se
+// mantiene para los servidores que aún no envían user_state.
+func handleUpdate(envelope Envelope, user *domain.User) error {
+	var payload UpdatePayload
+	if err := decodePayload(envelope, &payload); err != nil {
+		return err
+	}
+	UpdateUserStates(payload.ActiveUsers)
+	for _, activeUser := range payload.ActiveUsers {
+		if activeUser.Name == user.Name {
+			user.Active = activeUser.Active
+			log.Printf("Estado del usuario '%s' actualizado a: %v\n", user.Name, user.Active)
+			break
+		}
+	}
+	return nil
+}
+
+// handleUserState aplica el estado enviado solo a este agente.
+func handleUserState(envelope Envelope, user *domain.User) error {
+	var payload UserStatePayload
+	if err := decodePayload(envelope, &payload); err != nil {
+		return err
+	}
+	if payload.Active == nil {
+		return fmt.Errorf("el mensaje user_state no indica el estado active")
+	}
+
+	name := payload.Name
+	if name == "" {
+		name = user.Name
+	}
+	UpdateUserStates([]domain.User{{Name: name, Active: *payload.Active, InCall: payload.InCall}})
+	if userStateKey(name) == userStateKey(user.Name) {
+		user.Active = *payload.Active
+		log.Printf("Estado del usuario '%s' actualizado a: %v\n", user.Name, user.Active)
+	}
+	return nil
+}
+
+// handleRefresh vuelve a obtener la configuración.
+func handleRefresh(envelope Envelope, user *domain.User) error {
+	var payload RefreshPayload
+	if err := decodePayload(envelope, &payload); err != nil {
+		return err
+	}
+	log.Printf("Mensaje de actualización de configuración recibido: %s\n", envelope.Type)
+	return refreshConfiguration(user)
+}
+
+// handleStatus envía el estado actual del agente.
+func handleStatus(envelope Envelope, user *domain.User) error {
+	var payload StatusPayload
+	if err := decodePayload(envelope, &payload); err != nil {
+		return err
+	}
+	if err := sendStatusReport(); err != nil {
+		return fmt.Errorf("error enviando el estado del agente: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EdwinPirajan/bloqueo.git/internal/core/domain"
+	"github.com/gorilla/websocket"
+)
+
+// useFakeServer conecta el canal de control a un servidor WebSocket local y
+// retorna los sobres que el agente le envía.
+func useFakeServer(t *testing.T) <-chan Envelope {
+	t.Helper()
+	received := make(chan Envelope, 16)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var envelope Envelope
+			if err := conn.ReadJSON(&envelope); err != nil {
+				return
+			}
+			received <- envelope
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error conectando con el servidor falso: %v", err)
+	}
+	wsMutex.Lock()
+	wsConn = conn
+	wsMutex.Unlock()
+	t.Cleanup(func() {
+		wsMutex.Lock()
+		wsConn = nil
+		wsMutex.Unlock()
+		conn.Close()
+	})
+	return received
+}
+
+// useTestHandler registra un handler temporal para msgType.
+func useTestHandler(t *testing.T, msgType string, handler MessageHandler) {
+	t.Helper()
+	RegisterMessageHandler(msgType, handler)
+	t.Cleanup(func() {
+		messageHandlersMu.Lock()
+		delete(messageHandlers, msgType)
+		messageHandlersMu.Unlock()
+	})
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		version int
+		payload string
+		wantErr bool
+	}{
+		{
+			name:    "actual con payload",
+			message: `{"id":"m1","type":"user_state","version":1,"payload":{"active":true}}`,
+			version: 1,
+			payload: `{"active":true}`,
+		},
+		{
+			name:    "anterior con los datos en la raíz",
+			message: `{"type":"update","active_users":[{"name":"ana","active":true}]}`,
+			payload: `{"type":"update","active_users":[{"name":"ana","active":true}]}`,
+		},
+		{
+			name:    "anterior sin datos",
+			message: `{"type":"refresh"}`,
+			payload: `{"type":"refresh"}`,
+		},
+		{name: "sin tipo", message: `{"version":1,"payload":{}}`, wantErr: true},
+		{name: "JSON inválido", message: `{"type":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envelope, err := decodeEnvelope([]byte(tt.message))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("se esperaba un error, sobre %+v", envelope)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error inesperado: %v", err)
+			}
+			if envelope.Version != tt.version || string(envelope.Payload) != tt.payload {
+				t.Errorf("sobre = versión %d, payload %s; se esperaba versión %d, payload %s",
+					envelope.Version, envelope.Payload, tt.version, tt.payload)
+			}
+		})
+	}
+
+	// El payload de un mensaje anterior se interpreta igual que uno actual.
+	envelope, _ := decodeEnvelope([]byte(`{"type":"update","active_users":[{"name":"ana","active":true}]}`))
+	var update UpdatePayload
+	if err := decodePayload(envelope, &update); err != nil {
+		t.Fatal(err)
+	}
+	if len(update.ActiveUsers) != 1 || update.ActiveUsers[0].Name != "ana" {
+		t.Errorf("usuarios = %+v", update.ActiveUsers)
+	}
+}
+
+func TestDispatchEnvelope(t *testing.T) {
+	var handled []int
+	useTestHandler(t, "test_dispatch", func(envelope Envelope, user *domain.User) error {
+		handled = append(handled, envelope.Version)
+		return nil
+	})
+
+	tests := []struct {
+		name     string
+		envelope Envelope
+		wantErr  string
+	}{
+		{"versión anterior", Envelope{Type: "test_dispatch", Version: 0}, ""},
+		{"versión actual", Envelope{Type: "test_dispatch", Version: ProtocolVersion}, ""},
+		{"versión futura", Envelope{Type: "test_dispatch", Version: ProtocolVersion + 1}, "no soportada"},
+		{"tipo desconocido", Envelope{Type: "test_unknown", Version: ProtocolVersion}, "desconocido"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dispatchEnvelope(tt.envelope, &domain.User{})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("error inesperado: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, se esperaba que contuviera %q", err, tt.wantErr)
+			}
+		})
+	}
+	if len(handled) != 2 {
+		t.Errorf("el handler se ejecutó con las versiones %v, se esperaba [0 1]", handled)
+	}
+}
+
+func TestHandleEnvelopeReplies(t *testing.T) {
+	received := useFakeServer(t)
+	useTestHandler(t, "test_ok", func(Envelope, *domain.User) error { return nil })
+	useTestHandler(t, "test_fail", func(Envelope, *domain.User) error { return errors.New("no aplicado") })
+
+	tests := []struct {
+		name      string
+		envelope  Envelope
+		replyType string
+		wantErr   string
+	}{
+		{"aplicado", Envelope{ID: "m1", Type: "test_ok", Version: ProtocolVersion}, MessageAck, ""},
+		{"falla del handler", Envelope{ID: "m2", Type: "test_fail", Version: ProtocolVersion}, MessageNack, "no aplicado"},
+		{"versión futura", Envelope{ID: "m3", Type: "test_ok", Version: ProtocolVersion + 1}, MessageNack, "no soportada"},
+		{"tipo desconocido", Envelope{ID: "m4", Type: "test_unknown", Version: ProtocolVersion}, MessageNack, "desconocido"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handleEnvelope(tt.envelope, &domain.User{})
+			var reply Envelope
+			select {
+			case reply = <-received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no llegó la respuesta")
+			}
+			var ack AckPayload
+			if err := json.Unmarshal(reply.Payload, &ack); err != nil {
+				t.Fatal(err)
+			}
+			if reply.Type != tt.replyType || reply.Version != ProtocolVersion || ack.MessageID != tt.envelope.ID || ack.Type != tt.envelope.Type {
+				t.Errorf("respuesta = %s %+v, se esperaba %s para %s", reply.Type, ack, tt.replyType, tt.envelope.ID)
+			}
+			if !strings.Contains(ack.Error, tt.wantErr) || (tt.wantErr == "") != (ack.Error == "") {
+				t.Errorf("error = %q, se esperaba %q", ack.Error, tt.wantErr)
+			}
+		})
+	}
+
+	// Los mensajes sin ID (p. ej. del formato anterior) no se responden.
+	handleEnvelope(Envelope{Type: "test_fail"}, &domain.User{})
+	select {
+	case reply := <-received:
+		t.Errorf("se respondió un mensaje sin ID: %+v", reply)
+	case <-time.After(200 * time.Millisecond):
+	}
+}